@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// catalogMaxAge is how long a downloaded model catalog is trusted before
+// the app refreshes it by itself.
+const catalogMaxAge = 7 * 24 * time.Hour
+
+// modelInfo describes a model offered by the provider. Fields the provider
+// doesn't publish are left empty.
+type modelInfo struct {
+	ID            string      `json:"id"`
+	Name          string      `json:"name,omitempty"`
+	ContextLength int         `json:"context_length,omitempty"` // tokens
+	Modalities    []string    `json:"modalities,omitempty"`     // input modalities, e.g. "text", "image"
+	Price         *modelPrice `json:"price,omitempty"`
+}
+
+// vision reports whether the model accepts images; known is false when the
+// provider doesn't say.
+func (m modelInfo) vision() (supported, known bool) {
+	if len(m.Modalities) == 0 {
+		return false, false
+	}
+	return slices.Contains(m.Modalities, "image"), true
+}
+
+// modelCatalog is the list of models of one provider endpoint, cached on
+// disk so that the picker works offline.
+type modelCatalog struct {
+	Kind    string      `json:"kind"`
+	BaseURL string      `json:"base_url"`
+	Updated time.Time   `json:"updated"`
+	Models  []modelInfo `json:"models"`
+}
+
+func (c modelCatalog) stale() bool {
+	return time.Since(c.Updated) > catalogMaxAge
+}
+
+// lookup finds a model by ID. IDs without a vendor prefix, as used by
+// OpenAI and Anthropic directly, match the prefixed OpenRouter ID.
+func (c modelCatalog) lookup(id string) (modelInfo, bool) {
+	id = strings.TrimSpace(id)
+	for _, m := range c.Models {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	if !strings.Contains(id, "/") {
+		for _, m := range c.Models {
+			if strings.HasSuffix(m.ID, "/"+id) {
+				return m, true
+			}
+		}
+	}
+	return modelInfo{}, false
+}
+
+// search returns the models whose ID or name contains every word of query,
+// ignoring case.
+func (c modelCatalog) search(query string, visionOnly bool) []modelInfo {
+	words := strings.Fields(strings.ToLower(query))
+	var out []modelInfo
+	for _, m := range c.Models {
+		if visionOnly {
+			if ok, _ := m.vision(); !ok {
+				continue
+			}
+		}
+		text := strings.ToLower(m.ID + " " + m.Name)
+		match := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				match = false
+				break
+			}
+		}
+		if match {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// withoutVision returns the models among ids that are known not to accept
+// images. Models missing from the catalog are given the benefit of the doubt.
+func (c modelCatalog) withoutVision(ids []string) []string {
+	var out []string
+	for _, id := range ids {
+		if m, ok := c.lookup(id); ok {
+			if supported, known := m.vision(); known && !supported {
+				out = append(out, id)
+			}
+		}
+	}
+	return out
+}
+
+// formatTokens abbreviates a token count, as in 128k or 1M.
+func formatTokens(n int) string {
+	switch {
+	case n >= 1000000 && n%1000000 == 0:
+		return fmt.Sprintf("%dM", n/1000000)
+	case n >= 1000:
+		return fmt.Sprintf("%dk", n/1000)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// familyContextLengths are the context lengths of well-known model
+// families, by ID prefix without the vendor, for models no catalog
+// describes: OpenAI's and Ollama's model lists don't say, and Anthropic
+// has no catalog at all. Longer prefixes are matched first.
+var familyContextLengths = map[string]int{
+	"gpt-3.5-turbo": 16385,
+	"gpt-4":         8192,
+	"gpt-4-turbo":   128000,
+	"gpt-4o":        128000,
+	"gpt-4.1":       1047576,
+	"gpt-5":         400000,
+	"o1":            200000,
+	"o3":            200000,
+	"o4-mini":       200000,
+	"claude-":       200000,
+	"gemini-1.5":    1048576,
+	"gemini-2":      1048576,
+	"mistral-large": 128000,
+}
+
+// familyContextLength returns the context length of the family of model,
+// or 0 if it isn't a known one.
+func familyContextLength(model string) int {
+	id := strings.ToLower(strings.TrimSpace(model))
+	if i := strings.LastIndex(id, "/"); i >= 0 {
+		id = id[i+1:]
+	}
+	best, length := "", 0
+	for prefix, n := range familyContextLengths {
+		if strings.HasPrefix(id, prefix) && len(prefix) > len(best) {
+			best, length = prefix, n
+		}
+	}
+	return length
+}
+
+// contextLength returns the smallest context length among models, taking
+// each from the first catalog that knows it, or from its family, or 0 if
+// none is known. The whole chain must fit, since any model may end up
+// answering.
+func contextLength(models []string, catalogs ...modelCatalog) int {
+	least := 0
+	for _, id := range models {
+		n := 0
+		for _, c := range catalogs {
+			if m, ok := c.lookup(id); ok && m.ContextLength > 0 {
+				n = m.ContextLength
+				break
+			}
+		}
+		if n == 0 {
+			n = familyContextLength(id)
+		}
+		if n > 0 && (least == 0 || n < least) {
+			least = n
+		}
+	}
+	return least
+}
+
+// knownCatalogs returns the cached catalog of the provider, downloading it
+// first if fetch is set and there is none, followed by the cached OpenRouter
+// catalog, which also describes models used through other providers.
+func knownCatalogs(cfg providerConfig, fetch bool) []modelCatalog {
+	var out []modelCatalog
+	c, ok := loadCatalog(cfg)
+	if !ok && fetch && cfg.Kind != providerAnthropic {
+		c, _ = refreshCatalog(cfg)
+	}
+	if len(c.Models) > 0 {
+		out = append(out, c)
+	}
+	if cfg.Kind != providerOpenRouter {
+		if or, ok := readCatalog(providerOpenRouter); ok {
+			out = append(out, or)
+		}
+	}
+	return out
+}
+
+func catalogPath(kind string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "models-"+kind+".json"), nil
+}
+
+// loadCatalog returns the cached catalog for the provider, if it was
+// downloaded from the same endpoint.
+func loadCatalog(cfg providerConfig) (modelCatalog, bool) {
+	c, ok := readCatalog(cfg.Kind)
+	if !ok || c.BaseURL != cfg.baseURL() {
+		return modelCatalog{}, false
+	}
+	return c, true
+}
+
+func readCatalog(kind string) (modelCatalog, bool) {
+	path, err := catalogPath(kind)
+	if err != nil {
+		return modelCatalog{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return modelCatalog{}, false
+	}
+	var c modelCatalog
+	if json.Unmarshal(data, &c) != nil {
+		return modelCatalog{}, false
+	}
+	return c, true
+}
+
+// refreshCatalog downloads the provider's model list and caches it.
+// OpenRouter publishes context length, input modalities and prices; other
+// OpenAI-compatible servers and Ollama only the model IDs.
+func refreshCatalog(cfg providerConfig) (modelCatalog, error) {
+	c := modelCatalog{Kind: cfg.Kind, BaseURL: cfg.baseURL(), Updated: time.Now()}
+	switch cfg.Kind {
+	case providerAnthropic:
+		return c, fmt.Errorf("model listing is not supported for %s", providerLabel(cfg.Kind))
+	case providerOllama:
+		names, err := listModels(cfg)
+		if err != nil {
+			return c, err
+		}
+		for _, name := range names {
+			c.Models = append(c.Models, modelInfo{ID: name})
+		}
+	default:
+		models, err := fetchModels(cfg)
+		if err != nil {
+			return c, err
+		}
+		c.Models = models
+	}
+	if len(c.Models) == 0 {
+		return c, errors.New("the provider returned no models")
+	}
+	sort.Slice(c.Models, func(i, j int) bool { return c.Models[i].ID < c.Models[j].ID })
+
+	path, err := catalogPath(cfg.Kind)
+	if err != nil {
+		return c, err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return c, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return c, err
+	}
+	return c, os.WriteFile(path, data, 0o644)
+}
+
+// fetchModels reads an OpenAI-style /models list, with OpenRouter's extra
+// fields when present.
+func fetchModels(cfg providerConfig) ([]modelInfo, error) {
+	probe := cfg.HTTP
+	probe.Timeout = 15 * time.Second
+	ep, err := newEndpoint(probe, nil)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{}
+	if cfg.APIKey != "" {
+		headers["Authorization"] = "Bearer " + cfg.APIKey
+	}
+	var list struct {
+		Data []struct {
+			ID            string `json:"id"`
+			Name          string `json:"name"`
+			ContextLength int    `json:"context_length"`
+			Architecture  *struct {
+				InputModalities []string `json:"input_modalities"`
+			} `json:"architecture"`
+			Pricing *struct {
+				Prompt     string `json:"prompt"`
+				Completion string `json:"completion"`
+				Image      string `json:"image"`
+			} `json:"pricing"`
+		} `json:"data"`
+	}
+	if err := ep.getJSON(context.Background(), providerLabel(cfg.Kind), cfg.baseURL()+"/models", headers, &list); err != nil {
+		return nil, err
+	}
+
+	// Prices come as decimal strings; negative means "varies" (routers)
+	parse := func(s string) (float64, bool) {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return v, err == nil && v >= 0
+	}
+	var models []modelInfo
+	for _, d := range list.Data {
+		if d.ID == "" {
+			continue
+		}
+		m := modelInfo{ID: d.ID, Name: d.Name, ContextLength: d.ContextLength}
+		if d.Architecture != nil {
+			m.Modalities = d.Architecture.InputModalities
+		}
+		if d.Pricing != nil {
+			prompt, ok1 := parse(d.Pricing.Prompt)
+			completion, ok2 := parse(d.Pricing.Completion)
+			if ok1 && ok2 {
+				image, _ := parse(d.Pricing.Image)
+				m.Price = &modelPrice{Prompt: prompt, Completion: completion, Image: image}
+			}
+		}
+		models = append(models, m)
+	}
+	return models, nil
+}
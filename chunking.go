@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	chunkConcurrency = 3    // parallel requests during map-reduce generation
+	maxChunks        = 40   // upper bound on requests for a single generation
+	dupThreshold     = 0.75 // word overlap above which two questions count as the same
+)
+
+// Sizing the material of one request; sizes are in tokens unless noted.
+const (
+	defaultContextTokens = 8192   // for models whose context length isn't known
+	minInputTokens       = 1000   // below this a request carries too little material to be useful
+	contextMargin        = 0.1    // share of the context kept free, since token counts are guesses
+	bytesPerToken        = 3      // conservative: Italian and technical text tokenize densely
+	maxInputBytes        = 400000 // per request, even for million-token models
+)
+
+// inputBudget is how many bytes of material fit in one request to a model
+// with the given context length, after the instructions, the images and the
+// reply for n questions of style. Counting bytes rather than characters
+// errs on the safe side, since accented letters take two.
+func inputBudget(contextTokens, n int, style string, images int) int {
+	if contextTokens <= 0 {
+		contextTokens = defaultContextTokens
+	}
+	reply := min(n, questionsPerRequest(style)) * tokensPerQuestion(style)
+	free := int(float64(contextTokens)*(1-contextMargin)) - requestTokens - images*imageTokens - reply
+	if free < minInputTokens {
+		// Too small for the whole reply: the map-reduce chunks will each
+		// ask for fewer questions anyway
+		free = minInputTokens
+	}
+	return min(free*bytesPerToken, maxInputBytes)
+}
+
+// questionsPerRequest is how many questions of style fit in the reply of
+// one request. Anthropic caps replies at anthropicTokens and the other
+// providers have similar limits; asking for more gets a truncated reply.
+func questionsPerRequest(style string) int {
+	reply := float64(anthropicTokens) * (1 - contextMargin)
+	return max(1, int(reply)/tokensPerQuestion(style))
+}
+
+// materialChunks splits the documents of a generation into chunks that fit
+// the model's context. When opts.N questions don't fit in one reply, the
+// chunks are made small enough that each request asks for fewer.
+func materialChunks(opts generationOptions, mat material) []string {
+	size := inputBudget(opts.ContextLength, opts.N, opts.Style, len(mat.Images))
+	// Map-reduce asks for a quarter more than the share of each chunk
+	if requests := (opts.N*5/4 + questionsPerRequest(opts.Style) - 1) / questionsPerRequest(opts.Style); requests > 1 {
+		total := 0
+		for _, d := range mat.Docs {
+			for _, p := range d.Pages {
+				total += len(p.Text)
+			}
+		}
+		size = min(size, max(total/requests+1, minInputTokens*bytesPerToken))
+	}
+	return chunkMaterial(mat.Docs, size)
+}
+
+// splitIntoChunks cuts text into pieces of at most size bytes, preferring
+// paragraph, then line, then sentence and word boundaries. Cuts never fall
+// inside a UTF-8 sequence.
+func splitIntoChunks(text string, size int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if len(text) <= size {
+		return []string{text}
+	}
+
+	var chunks []string
+	for len(text) > size {
+		cut := lastBoundary(text, size)
+		chunks = append(chunks, strings.TrimSpace(text[:cut]))
+		text = strings.TrimSpace(text[cut:])
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// lastBoundary returns where to cut text so that the first piece is at most
+// size bytes and ends on the most natural boundary in its second half.
+func lastBoundary(text string, size int) int {
+	s := text[:size]
+	half := size / 2
+	for _, sep := range []string{"\n\n", "\n", ". ", " "} {
+		if i := strings.LastIndex(s, sep); i > half {
+			return i + len(sep)
+		}
+	}
+	// No boundary at all: back off to the start of a rune
+	cut := size
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	if cut == 0 {
+		cut = size // not valid UTF-8 anyway
+	}
+	return cut
+}
+
+// cutUTF8 shortens s to at most n bytes without splitting a character.
+func cutUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// chunkJob is one request of a map-reduce generation.
+type chunkJob struct {
+	text   string
+	images []sourceImage
+	n      int
+}
+
+// planChunkJobs distributes n questions across the chunks in proportion to
+// their length, with a little headroom for deduplication. A chunk whose
+// share doesn't fit in one reply of perRequest questions gets several
+// requests, one after the other in the plan. When there are more chunks
+// than the budget allows, evenly spaced chunks are kept so the whole
+// document is still covered.
+func planChunkJobs(n, perRequest int, chunks []string, images []sourceImage) []chunkJob {
+	limit := maxChunks
+	if n < limit {
+		limit = n
+	}
+	if len(images) > 0 && limit > 1 {
+		limit-- // the images get a request of their own
+	}
+	if len(chunks) > limit {
+		picked := make([]string, 0, limit)
+		for i := 0; i < limit; i++ {
+			picked = append(picked, chunks[i*len(chunks)/limit])
+		}
+		chunks = picked
+	}
+
+	weights := make([]int, 0, len(chunks)+1)
+	total := 0
+	for _, c := range chunks {
+		weights = append(weights, len(c))
+		total += len(c)
+	}
+	if len(images) > 0 {
+		// Weigh the images like an average chunk
+		avg := 1
+		if len(chunks) > 0 {
+			avg = max(1, total/len(chunks))
+		}
+		weights = append(weights, avg)
+		total += avg
+	}
+
+	jobs := make([]chunkJob, 0, len(weights))
+	for i, wt := range weights {
+		share := (n*wt + total - 1) / total // round up
+		want := share + (share+2)/4
+		requests := (want + perRequest - 1) / perRequest
+		for j := 0; j < requests; j++ {
+			job := chunkJob{n: (want + requests - 1) / requests}
+			if i < len(chunks) {
+				job.text = chunks[i]
+			} else {
+				job.images = images
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// generateMapReduce runs one request per chunk with bounded concurrency and
+// merges the results into a single set of n questions.
+func generateMapReduce(ctx context.Context, p Provider, opts generationOptions, chunks []string, images []sourceImage) (*QuestionSet, Usage, error) {
+	n := opts.N
+	jobs := planChunkJobs(n, questionsPerRequest(opts.Style), chunks, images)
+
+	results := make([][]Question, len(jobs))
+	models := make([]string, len(jobs))
+	errs := make([]error, len(jobs))
+	usages := make([]Usage, len(jobs))
+
+	// Progress of all chunks together, in chunk order
+	var progressMu sync.Mutex
+	partial := make([]generationProgress, len(jobs))
+	report := func(i int, pr generationProgress) {
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		partial[i] = pr
+		var total generationProgress
+		for _, part := range partial {
+			total.Questions = append(total.Questions, part.Questions...)
+			total.Tokens += part.Tokens
+		}
+		opts.OnProgress(total)
+	}
+
+	sem := make(chan struct{}, chunkConcurrency)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(i int, job chunkJob) {
+			defer wg.Done()
+			// Chunks still waiting for a slot are skipped once cancelled
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			jobOpts := opts
+			jobOpts.N = job.n
+			if opts.OnProgress != nil {
+				jobOpts.OnProgress = func(pr generationProgress) { report(i, pr) }
+			}
+			qs, used, err := generateChunk(ctx, p, jobOpts, job.text, job.images)
+			usages[i] = used
+			if err != nil {
+				errs[i] = fmt.Errorf("parte %d/%d: %w", i+1, len(jobs), err)
+				return
+			}
+			results[i] = qs.Questions
+			models[i] = qs.Model
+		}(i, job)
+	}
+	wg.Wait()
+
+	var usage Usage
+	for _, u := range usages {
+		usage.Add(u)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, usage, err
+	}
+	var firstErr error
+	got := 0
+	for i := range jobs {
+		if errs[i] != nil && firstErr == nil {
+			firstErr = errs[i]
+		}
+		got += len(results[i])
+	}
+	// A failed chunk is tolerable as long as the others produced enough
+	if firstErr != nil && got < n {
+		return nil, usage, firstErr
+	}
+
+	merged := mergeBalanced(results, n, opts.seen)
+	if len(merged) == 0 {
+		return nil, usage, fmt.Errorf("no questions generated")
+	}
+	// Name every model that contributed, in case some chunks fell back
+	var used []string
+	for _, m := range models {
+		if m != "" && !slices.Contains(used, m) {
+			used = append(used, m)
+		}
+	}
+	// Say how much of the material was left out for lack of questions;
+	// the requests of a chunk are next to each other in the plan
+	asked := 0
+	for i, job := range jobs {
+		if job.text != "" && (i == 0 || job.text != jobs[i-1].text) {
+			asked++
+		}
+	}
+	qs := &QuestionSet{Model: strings.Join(used, ", "), Questions: merged, Parts: len(chunks), Skipped: len(chunks) - asked}
+	qs.Renumber()
+	return qs, usage, nil
+}
+
+// mergeBalanced deduplicates the per-chunk questions and picks n of them
+// round-robin across chunks, so every part of the document is represented.
+// The result keeps document order. seen, when not nil, holds the words of
+// questions picked before, which count as duplicates too; the words of the
+// questions picked now are added to it.
+func mergeBalanced(perChunk [][]Question, n int, seen *[][]string) []Question {
+	var known [][]string
+	if seen != nil {
+		known = slices.Clone(*seen)
+	}
+	unique := make([][]Question, len(perChunk))
+	for i, qs := range perChunk {
+		unique[i] = uniqueQuestions(qs, &known)
+	}
+
+	taken := make([]int, len(unique))
+	count := 0
+	for round := 0; count < n; round++ {
+		progress := false
+		for i := range unique {
+			if count == n {
+				break
+			}
+			if round < len(unique[i]) {
+				taken[i]++
+				count++
+				progress = true
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+
+	var out []Question
+	for i := range unique {
+		out = append(out, unique[i][:taken[i]]...)
+	}
+	if seen != nil {
+		for _, q := range out {
+			*seen = append(*seen, questionWords(q))
+		}
+	}
+	return out
+}
+
+// uniqueQuestions drops the questions of qs that repeat one in seen, or an
+// earlier one in qs, and adds the words of those it keeps to seen.
+func uniqueQuestions(qs []Question, seen *[][]string) []Question {
+	var out []Question
+	for _, q := range qs {
+		words := questionWords(q)
+		dup := false
+		for _, other := range *seen {
+			if wordOverlap(words, other) >= dupThreshold {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			*seen = append(*seen, words)
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+// questionWords returns the lowercased words of a question stem, ignoring
+// short function words. The items of sequence and matching questions count
+// too, since their stems are often the same generic instruction.
+func questionWords(q Question) []string {
+	text := q.Stem
+	for _, item := range q.Sequence {
+		text += " " + item
+	}
+	for _, pr := range q.Pairs {
+		text += " " + pr.Left + " " + pr.Right
+	}
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var words []string
+	for _, f := range fields {
+		if utf8.RuneCountInString(f) > 3 {
+			words = append(words, f)
+		}
+	}
+	return words
+}
+
+// wordOverlap is the Jaccard similarity of two word lists.
+func wordOverlap(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	set := make(map[string]bool, len(a))
+	for _, w := range a {
+		set[w] = true
+	}
+	inter := 0
+	union := len(set)
+	seenB := map[string]bool{}
+	for _, w := range b {
+		if seenB[w] {
+			continue
+		}
+		seenB[w] = true
+		if set[w] {
+			inter++
+		} else {
+			union++
+		}
+	}
+	return float64(inter) / float64(union)
+}
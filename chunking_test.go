@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitIntoChunks(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		size int
+		want []string
+	}{
+		{"empty", "  \n ", 10, nil},
+		{"fits", "breve", 10, []string{"breve"}},
+		{"paragraphs", "primo paragrafo\n\nsecondo paragrafo", 20, []string{"primo paragrafo", "secondo paragrafo"}},
+		{"sentences", "Prima frase. Seconda frase.", 16, []string{"Prima frase.", "Seconda frase."}},
+		{"words", "uno due tre quattro", 12, []string{"uno due tre", "quattro"}},
+		{"no boundary in the second half", "uno due tre quattro", 9, []string{"uno due", "tre quatt", "ro"}},
+		{"accents without spaces", "èèèèè", 3, []string{"è", "è", "è", "è", "è"}},
+		{"mixed runes", "aèèè", 4, []string{"aè", "èè"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitIntoChunks(tt.text, tt.size)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("splitIntoChunks(%q, %d) = %q, want %q", tt.text, tt.size, got, tt.want)
+			}
+			for _, c := range got {
+				if len(c) > tt.size {
+					t.Errorf("chunk %q is longer than %d bytes", c, tt.size)
+				}
+				if !utf8.ValidString(c) {
+					t.Errorf("chunk %q splits a character", c)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitIntoChunksKeepsText(t *testing.T) {
+	text := strings.Repeat("Perché la città è così? Però sì. ", 200)
+	for _, size := range []int{7, 50, 333, 1000} {
+		chunks := splitIntoChunks(text, size)
+		// Only whitespace at the cuts may go
+		got := strings.Join(strings.Fields(strings.Join(chunks, "")), "")
+		if want := strings.Join(strings.Fields(text), ""); got != want {
+			t.Errorf("size %d: the chunks don't add up to the text", size)
+		}
+		for _, c := range chunks {
+			if len(c) > size || !utf8.ValidString(c) {
+				t.Errorf("size %d: bad chunk %q", size, c)
+			}
+		}
+	}
+}
+
+func TestPlanChunkJobs(t *testing.T) {
+	chunks := func(sizes ...int) []string {
+		var out []string
+		for i, n := range sizes {
+			out = append(out, strings.Repeat(string(rune('a'+i)), n))
+		}
+		return out
+	}
+	tests := []struct {
+		name       string
+		n          int
+		perRequest int
+		chunks     []string
+		images     int
+		wantN      []int
+		wantText   string // first letter of the text of each job, "-" for images
+	}{
+		{"equal chunks", 10, 100, chunks(100, 100), 0, []int{6, 6}, "ab"},
+		{"proportional", 10, 100, chunks(300, 100), 0, []int{10, 4}, "ab"},
+		{"images get a job", 6, 100, chunks(100, 100), 1, []int{3, 3, 3}, "ab-"},
+		{"several requests per chunk", 40, 10, chunks(100, 100), 0, []int{9, 9, 9, 9, 9, 9}, "aaabbb"},
+		{"several requests for the images", 20, 10, nil, 1, []int{9, 9, 9}, "---"},
+		{"more chunks than questions", 3, 100, chunks(10, 10, 10, 10, 10, 10), 0, []int{1, 1, 1}, "ace"},
+		{"one question", 1, 100, chunks(10, 10, 10, 10), 0, []int{1}, "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var images []sourceImage
+			for i := 0; i < tt.images; i++ {
+				images = append(images, sourceImage{Name: fmt.Sprintf("%d.png", i)})
+			}
+			jobs := planChunkJobs(tt.n, tt.perRequest, tt.chunks, images)
+			var gotN []int
+			var gotText string
+			for _, j := range jobs {
+				gotN = append(gotN, j.n)
+				if j.text == "" {
+					gotText += "-"
+				} else {
+					gotText += j.text[:1]
+				}
+			}
+			if fmt.Sprint(gotN) != fmt.Sprint(tt.wantN) || gotText != tt.wantText {
+				t.Errorf("planChunkJobs(%d) = %v %q, want %v %q", tt.n, gotN, gotText, tt.wantN, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestMergeBalanced(t *testing.T) {
+	q := func(stem string) Question { return Question{Stem: stem} }
+	perChunk := [][]Question{
+		{q("Quando nasce Roma antica"), q("Chi fondò Roma antica"), q("Perché Roma cresce rapidamente")},
+		{q("Cosa sono acquedotti romani"), q("Quando nasce Roma antica?")}, // duplicate of the first
+		{q("Dove combatté Annibale cartaginese")},
+	}
+	stems := func(qs []Question) string {
+		var s []string
+		for _, q := range qs {
+			s = append(s, q.Stem)
+		}
+		return strings.Join(s, " | ")
+	}
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{1, "Quando nasce Roma antica"},
+		{3, "Quando nasce Roma antica | Cosa sono acquedotti romani | Dove combatté Annibale cartaginese"},
+		{4, "Quando nasce Roma antica | Chi fondò Roma antica | Cosa sono acquedotti romani | Dove combatté Annibale cartaginese"},
+		{10, "Quando nasce Roma antica | Chi fondò Roma antica | Perché Roma cresce rapidamente | Cosa sono acquedotti romani | Dove combatté Annibale cartaginese"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.n), func(t *testing.T) {
+			got := mergeBalanced(perChunk, tt.n, nil)
+			if stems(got) != tt.want {
+				t.Errorf("mergeBalanced(%d) = %q, want %q", tt.n, stems(got), tt.want)
+			}
+		})
+	}
+
+	t.Run("seen", func(t *testing.T) {
+		var seen [][]string
+		first := mergeBalanced([][]Question{{q("Chi fondò Roma antica"), q("Perché Roma cresce rapidamente")}}, 1, &seen)
+		if stems(first) != "Chi fondò Roma antica" || len(seen) != 1 {
+			t.Fatalf("first share = %q, %d seen", stems(first), len(seen))
+		}
+		// Only what was picked counts as seen, not what was trimmed away
+		second := mergeBalanced([][]Question{{q("Chi fondò Roma antica?"), q("Perché Roma cresce rapidamente")}}, 2, &seen)
+		if stems(second) != "Perché Roma cresce rapidamente" {
+			t.Errorf("second share = %q", stems(second))
+		}
+	})
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Exit codes of the headless commands, so scripts can tell what went wrong.
+const (
+	exitOK       = 0
+	exitFailure  = 1 // the generation itself failed (provider, network, bad output)
+	exitUsage    = 2 // invalid flags or configuration
+	exitNoInputs = 3 // input files missing, unreadable or without usable content
+	exitBudget   = 4 // the run would exceed LAZYQ_BUDGET_DAY or LAZYQ_BUDGET_MONTH
+)
+
+// command is a headless subcommand; without one LazyQ starts the GUI.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+func commands() []command {
+	return []command{
+		{"generate", "generate questions from PDFs and images without the GUI", runGenerate},
+		{"serve", "run a local REST API for generating questions", runServe},
+		{"models", "search the provider's models, with context length, image support and price", runModels},
+		{"usage", "show token and cost totals, or export the usage ledger as CSV", runUsage},
+	}
+}
+
+// runCommand runs the subcommand named by args[0], if there is one.
+// ok is false when args don't start with a subcommand.
+func runCommand(args []string) (code int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printCommands(os.Stdout)
+		return exitOK, true
+	}
+	for _, c := range commands() {
+		if c.name == args[0] {
+			return c.run(args[1:]), true
+		}
+	}
+	return 0, false
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintf(w, "Usage: lazyq [command] [flags]\n\nWithout a command the graphical app starts.\n\nCommands:\n")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun \"lazyq <command> -h\" for the flags of a command.\n")
+}
+
+// cliProviderFlags are the provider settings shared by the headless commands.
+// The API key is never a flag (it would show up in ps): it comes from
+// LAZYQ_API_KEY, or OPENROUTER_API_KEY for OpenRouter.
+type cliProviderFlags struct {
+	kind     *string
+	baseURL  *string
+	timeout  *string
+	attempts *int
+}
+
+func addProviderFlags(fs *flag.FlagSet) cliProviderFlags {
+	return cliProviderFlags{
+		kind:     fs.String("provider", providerOpenRouter, "provider: "+strings.Join(providerKinds, ", ")),
+		baseURL:  fs.String("base-url", "", "API endpoint (default depends on the provider)"),
+		timeout:  fs.String("timeout", "", "HTTP timeout, seconds or a duration like 5m (default 90s)"),
+		attempts: fs.Int("retries", 0, fmt.Sprintf("attempts per request on rate limits and outages, 1-%d (default %d)", maxAttempts, defaultAttempts)),
+	}
+}
+
+// config builds the provider configuration from the flags and the LAZYQ_*
+// environment variables.
+func (f cliProviderFlags) config() (providerConfig, error) {
+	kind := strings.ToLower(strings.TrimSpace(*f.kind))
+	if _, ok := providerLabels[kind]; !ok {
+		return providerConfig{}, fmt.Errorf("unknown provider %q (want one of %s)", *f.kind, strings.Join(providerKinds, ", "))
+	}
+	cfg := providerConfig{Kind: kind}
+	if kind == providerOpenRouter {
+		cfg.APIKey = strings.TrimSpace(os.Getenv("OPENROUTER_API_KEY"))
+	}
+	timeout, err := parseTimeout(*f.timeout)
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	// A flag on the command line beats the environment
+	if *f.baseURL != "" {
+		cfg.BaseURL = strings.TrimSpace(*f.baseURL)
+	}
+	if timeout > 0 {
+		cfg.HTTP.Timeout = timeout
+	}
+	if *f.attempts != 0 {
+		if *f.attempts < 1 || *f.attempts > maxAttempts {
+			return cfg, fmt.Errorf("--retries must be between 1 and %d", maxAttempts)
+		}
+		cfg.HTTP.Attempts = *f.attempts
+	}
+	if cfg.needsKey() && cfg.APIKey == "" {
+		return cfg, fmt.Errorf("%s needs an API key: set %s", providerLabel(kind), envAPIKey)
+	}
+	return cfg, nil
+}
+
+// styleAliases are the ASCII names accepted by --style besides the UI labels.
+var styleAliases = map[string]string{
+	"standard":  "",
+	"mcq":       styleMultipleChoice,
+	"cloze":     styleCloze,
+	"truefalse": styleTrueFalse,
+	"sequence":  styleSequence,
+	"matching":  styleMatching,
+	"complex":   styleComplex,
+	"essay":     styleEssay,
+	"numbers":   styleNumbers,
+}
+
+func parseStyle(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if style, ok := styleAliases[strings.ToLower(s)]; ok || s == "" {
+		return style, nil
+	}
+	for _, style := range questionStyles {
+		if strings.EqualFold(style, s) {
+			return style, nil
+		}
+	}
+	var names []string
+	for alias := range styleAliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("unknown style %q (want one of %s)", s, strings.Join(names, ", "))
+}
+
+// parseMix reads the distribution of a mixed set, as in
+// "mcq=4,truefalse=3,sequence=2,standard=1". Styles are those of --style;
+// a style given twice gets the sum of its counts.
+func parseMix(s string) ([]styleShare, error) {
+	var mix []styleShare
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' }) {
+		name, count, ok := strings.Cut(part, "=")
+		if !ok {
+			name, count, ok = strings.Cut(part, ":")
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid mix entry %q, expected style=count", strings.TrimSpace(part))
+		}
+		style, err := parseStyle(name)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid count %q for %s", strings.TrimSpace(count), styleLabel(style))
+		}
+		i := slices.IndexFunc(mix, func(sh styleShare) bool { return sh.Style == style })
+		switch {
+		case n == 0:
+		case i >= 0:
+			mix[i].N += n
+		default:
+			mix = append(mix, styleShare{Style: style, N: n})
+		}
+	}
+	if total := mixTotal(mix); total < 1 || total > 100 {
+		return nil, fmt.Errorf("the mix must add up to 1-100 questions, not %d", total)
+	}
+	return mix, nil
+}
+
+// parseInterspersed parses flags that may come after the file arguments, as
+// in "lazyq generate notes.pdf --n 20", and returns the file arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return rest, nil
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
+func runGenerate(args []string) int {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	pf := addProviderFlags(fs)
+	model := fs.String("model", "", "model ID, or comma-separated IDs tried in order (default depends on the provider)")
+	n := fs.Int("n", defaultN, "number of questions (1-100)")
+	styleFlag := fs.String("style", "", "question style: standard, mcq, cloze, truefalse, sequence, matching, complex, essay or numbers")
+	mixFlag := fs.String("mix", "", "mixed set as style=count pairs, e.g. mcq=4,truefalse=3,sequence=2,standard=1 (replaces --n and --style)")
+	lang := fs.String("lang", "", "language of the questions, e.g. it, en, fr (default it)")
+	out := fs.String("out", "", "output file; .json writes JSON, anything else plain text (default stdout)")
+	format := fs.String("format", "", "output format: json or text (default from --out, json on stdout)")
+	quiet := fs.Bool("q", false, "don't print progress on stderr")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lazyq generate [flags] file.pdf img.png 'notes/*.pdf' ...\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nThe API key is read from %s (or OPENROUTER_API_KEY); the other LAZYQ_* variables apply as in the app.\n", envAPIKey)
+		fmt.Fprintf(fs.Output(), "Spending caps in dollars are read from %s and %s.\n", envBudgetDay, envBudgetMonth)
+		fmt.Fprintf(fs.Output(), "Exit codes: %d ok, %d generation failed, %d invalid usage, %d no usable input, %d over budget.\n", exitOK, exitFailure, exitUsage, exitNoInputs, exitBudget)
+	}
+
+	patterns, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+	fail := func(code int, format string, a ...interface{}) int {
+		fmt.Fprintf(os.Stderr, "lazyq generate: "+format+"\n", a...)
+		return code
+	}
+
+	if len(patterns) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if *n < 1 || *n > 100 {
+		return fail(exitUsage, "--n must be between 1 and 100")
+	}
+	style, err := parseStyle(*styleFlag)
+	if err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+	var mix []styleShare
+	if *mixFlag != "" {
+		if *styleFlag != "" {
+			return fail(exitUsage, "--mix and --style can't be used together")
+		}
+		if mix, err = parseMix(*mixFlag); err != nil {
+			return fail(exitUsage, "--mix: %v", err)
+		}
+		*n = mixTotal(mix)
+	}
+	asJSON, err := outputIsJSON(*format, *out)
+	if err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+	cfg, err := pf.config()
+	if err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+	provider, err := newProvider(cfg)
+	if err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+	models := parseModelList(*model)
+	if len(models) == 0 {
+		models = []string{defaultModelFor(cfg.Kind)}
+	}
+	opts := generationOptions{Model: models[0], Fallbacks: models[1:], N: *n, Style: style, Mix: mix, Language: *lang}
+	opts.ContextLength = contextLength(models, knownCatalogs(cfg, true)...)
+
+	mat, files, err := loadMaterial(patterns)
+	if err != nil {
+		return fail(exitNoInputs, "%v", err)
+	}
+
+	logf := func(format string, a ...interface{}) {
+		if !*quiet {
+			fmt.Fprintf(os.Stderr, format+"\n", a...)
+		}
+	}
+	logf("Generating %d questions from %d file(s) with %s (%s)...", opts.N, len(files), strings.Join(models, ", "), providerLabel(cfg.Kind))
+	if len(mix) > 0 {
+		logf("Mixed set: %s, one generation per style.", mixText(mix))
+	}
+	if c, ok := loadCatalog(cfg); ok && len(mat.Images) > 0 {
+		if noVision := c.withoutVision(models); len(noVision) > 0 {
+			logf("Warning: %s doesn't accept images, the request will likely fail.", strings.Join(noVision, ", "))
+		}
+	}
+	est, priced := estimateUsage(opts, mat, loadPrices())
+	if cfg.needsKey() && priced {
+		logf("Estimated cost ~%s (~%d tokens).", formatCost(est.Cost), est.PromptTokens+est.CompletionTokens)
+	}
+	var b budget
+	if err := b.applyEnv(); err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+	if err := b.checkRun(opts.Model, est.Cost, priced, cfg.needsKey()); err != nil {
+		return fail(exitBudget, "%v", err)
+	}
+	live := !*quiet && isTerminal(os.Stderr)
+	if live {
+		opts.OnProgress = func(pr generationProgress) {
+			fmt.Fprintf(os.Stderr, "\r%d questions, ~%d tokens", len(pr.Questions), pr.Tokens)
+		}
+	}
+	// Ctrl-C or a SIGTERM from cron/systemd abort the requests in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+	qs, usage, err := generateQuestionSet(ctx, provider, opts, mat)
+	if live {
+		fmt.Fprintln(os.Stderr)
+	}
+	usage = recordRun("cli", provider.Name(), opts, qs, usage, err)
+	if !usage.Zero() {
+		logf("%s", usageLine(usage))
+	}
+	if errors.Is(err, context.Canceled) {
+		return fail(exitFailure, "interrupted")
+	}
+	if err != nil {
+		return fail(exitFailure, "%v", err)
+	}
+
+	if err := writeQuestionSet(qs, *out, asJSON); err != nil {
+		return fail(exitFailure, "%v", err)
+	}
+	dest := *out
+	if dest == "" || dest == "-" {
+		dest = "stdout"
+	}
+	logf("Wrote %d questions by %s to %s in %s.", len(qs.Questions), qs.Model, dest, time.Since(start).Truncate(time.Millisecond))
+	if qs.Skipped > 0 {
+		logf("Warning: the material was split into %d parts and %d of them, evenly spaced, got no questions; ask for more questions to cover it all.", qs.Parts, qs.Skipped)
+	}
+	if qs.Requested > 0 {
+		logf("Warning: only %d of the %d requested questions were generated, the model produced no others distinct from these; add material or ask for fewer.", len(qs.Questions), qs.Requested)
+	}
+	return exitOK
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func outputIsJSON(format, out string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "json":
+		return true, nil
+	case "text", "txt":
+		return false, nil
+	case "":
+		if out == "" || out == "-" {
+			return true, nil
+		}
+		return strings.EqualFold(filepath.Ext(out), ".json"), nil
+	}
+	return false, fmt.Errorf("unknown format %q (want json or text)", format)
+}
+
+func writeQuestionSet(qs *QuestionSet, out string, asJSON bool) error {
+	write := func(w io.Writer) error {
+		if asJSON {
+			return qs.WriteJSON(w)
+		}
+		_, err := io.WriteString(w, qs.Text()+"\n")
+		return err
+	}
+	if out == "" || out == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadMaterial expands the file arguments (which may be glob patterns, for
+// shells and cron entries that don't expand them) and reads every PDF and
+// image, using all pages of each PDF.
+func loadMaterial(patterns []string) (material, []string, error) {
+	var mat material
+	var files []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return mat, nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			if _, err := os.Stat(pattern); err != nil {
+				return mat, nil, fmt.Errorf("no files match %s", pattern)
+			}
+			matches = []string{pattern}
+		}
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				files = append(files, path)
+			}
+		}
+	}
+
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return mat, nil, err
+		}
+		if err := mat.add(filepath.Base(path), data); err != nil {
+			return mat, nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if mat.empty() {
+		return mat, nil, errors.New("no input files")
+	}
+	return mat, files, nil
+}
+
+// usageLine describes what a run consumed.
+func usageLine(u Usage) string {
+	cost := "no cost (local model or not in the price list)"
+	if u.Cost > 0 {
+		cost = "cost " + formatCost(u.Cost)
+	}
+	return fmt.Sprintf("Tokens: %d prompt, %d completion, %s.", u.PromptTokens, u.CompletionTokens, cost)
+}
+
+func runUsage(args []string) int {
+	fs := flag.NewFlagSet("usage", flag.ContinueOnError)
+	daily := fs.Bool("daily", false, "total by day instead of by month")
+	csvOut := fs.String("csv", "", "export every recorded run to this CSV file (- for stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lazyq usage [flags]\n\n")
+		fs.PrintDefaults()
+		if path, err := ledgerPath(); err == nil {
+			fmt.Fprintf(fs.Output(), "\nThe ledger is %s (set %s to move it).\n", path, envLedger)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	entries, err := readLedger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lazyq usage: %v\n", err)
+		return exitFailure
+	}
+	if *csvOut != "" {
+		w := io.Writer(os.Stdout)
+		if *csvOut != "-" {
+			f, err := os.Create(*csvOut)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "lazyq usage: %v\n", err)
+				return exitFailure
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := writeLedgerCSV(w, entries); err != nil {
+			fmt.Fprintf(os.Stderr, "lazyq usage: %v\n", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	period, name := periodMonth, "Month"
+	if *daily {
+		period, name = periodDay, "Day"
+	}
+	fmt.Printf("%-10s %6s %12s %12s %10s\n", name, "Runs", "Prompt", "Completion", "Cost")
+	for _, t := range ledgerTotals(entries, period) {
+		fmt.Printf("%-10s %6d %12d %12d %10s\n", t.Period, t.Runs, t.Usage.PromptTokens, t.Usage.CompletionTokens, formatCost(t.Usage.Cost))
+	}
+	return exitOK
+}
+
+func runModels(args []string) int {
+	fs := flag.NewFlagSet("models", flag.ContinueOnError)
+	pf := addProviderFlags(fs)
+	refresh := fs.Bool("refresh", false, "download the list again even if the cached one is recent")
+	vision := fs.Bool("vision", false, "only models that accept images")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lazyq models [flags] [search words]\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nThe list is cached and used when the provider can't be reached.\n")
+	}
+	words, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+	fail := func(code int, format string, a ...interface{}) int {
+		fmt.Fprintf(os.Stderr, "lazyq models: "+format+"\n", a...)
+		return code
+	}
+	cfg, err := pf.config()
+	if err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+
+	catalog, cached := loadCatalog(cfg)
+	if *refresh || !cached || catalog.stale() {
+		fresh, err := refreshCatalog(cfg)
+		switch {
+		case len(fresh.Models) > 0:
+			catalog = fresh
+		case cached:
+			fmt.Fprintf(os.Stderr, "lazyq models: %v; using the list of %s\n", err, catalog.Updated.Format("2006-01-02"))
+		default:
+			return fail(exitFailure, "%v", err)
+		}
+	}
+
+	fmt.Printf("%-50s %8s %7s %12s %12s\n", "Model", "Context", "Images", "$/M prompt", "$/M output")
+	for _, m := range catalog.search(strings.Join(words, " "), *vision) {
+		ctxLen, images, prompt, output := "-", "?", "-", "-"
+		if m.ContextLength > 0 {
+			ctxLen = formatTokens(m.ContextLength)
+		}
+		if supported, known := m.vision(); known {
+			images = "no"
+			if supported {
+				images = "yes"
+			}
+		}
+		if m.Price != nil {
+			prompt = fmt.Sprintf("%.2f", m.Price.Prompt*1e6)
+			output = fmt.Sprintf("%.2f", m.Price.Completion*1e6)
+		}
+		fmt.Printf("%-50s %8s %7s %12s %12s\n", m.ID, ctxLen, images, prompt, output)
+	}
+	return exitOK
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestParseStyle(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "standard", want: ""},
+		{in: " MCQ ", want: styleMultipleChoice},
+		{in: "truefalse", want: styleTrueFalse},
+		{in: "vero o falso", want: styleTrueFalse},
+		{in: "Sequenziale", want: styleSequence},
+		{in: "quiz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseStyle(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseStyle(%q) = %q, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("parseStyle(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		wantFiles []string
+		wantN     int
+		wantErr   bool
+	}{
+		{name: "flags first", args: []string{"--n", "5", "a.pdf", "b.png"}, wantFiles: []string{"a.pdf", "b.png"}, wantN: 5},
+		{name: "flags last", args: []string{"a.pdf", "b.png", "--n", "5"}, wantFiles: []string{"a.pdf", "b.png"}, wantN: 5},
+		{name: "flags between", args: []string{"a.pdf", "-n=5", "b.png"}, wantFiles: []string{"a.pdf", "b.png"}, wantN: 5},
+		{name: "no flags", args: []string{"a.pdf"}, wantFiles: []string{"a.pdf"}, wantN: defaultN},
+		{name: "unknown flag", args: []string{"a.pdf", "--pages", "3"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			n := fs.Int("n", defaultN, "")
+			files, err := parseInterspersed(fs, tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseInterspersed(%q) = %q, want an error", tt.args, files)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(files, tt.wantFiles) || *n != tt.wantN {
+				t.Errorf("parseInterspersed(%q) = %q, n=%d, %v, want %q, n=%d", tt.args, files, *n, err, tt.wantFiles, tt.wantN)
+			}
+		})
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []styleShare
+		wantErr bool
+	}{
+		{in: "mcq=4,truefalse=3,sequence=2,standard=1", want: []styleShare{{styleMultipleChoice, 4}, {styleTrueFalse, 3}, {styleSequence, 2}, {"", 1}}},
+		{in: " MCQ : 2 ; vero o falso=1", want: []styleShare{{styleMultipleChoice, 2}, {styleTrueFalse, 1}}},
+		{in: "mcq=2,truefalse=1,mcq=3", want: []styleShare{{styleMultipleChoice, 5}, {styleTrueFalse, 1}}},
+		{in: "mcq=0,truefalse=2", want: []styleShare{{styleTrueFalse, 2}}},
+		{in: "mcq", wantErr: true},
+		{in: "mcq=tre", wantErr: true},
+		{in: "mcq=-1", wantErr: true},
+		{in: "quiz=2", wantErr: true},
+		{in: "mcq=0", wantErr: true},
+		{in: "mcq=60,truefalse=41", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseMix(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseMix(%q) = %v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("parseMix(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
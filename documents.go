@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	pdf "github.com/ledongthuc/pdf"
+)
+
+// pageText is the extracted text of one PDF page.
+type pageText struct {
+	Number int // 1-based
+	Text   string
+}
+
+// sourceDoc is a text document added by the user, kept page by page so
+// that questions can cite where they come from.
+type sourceDoc struct {
+	Name  string
+	Pages []pageText
+}
+
+// sourceImage is an image added by the user.
+type sourceImage struct {
+	Name    string
+	DataURL string
+}
+
+// material is everything a generation is based on.
+type material struct {
+	Docs   []sourceDoc
+	Images []sourceImage
+}
+
+func (m material) empty() bool {
+	return len(m.Docs) == 0 && len(m.Images) == 0
+}
+
+// add reads a PDF, using all of its pages, or an image into the material,
+// picking the kind from the extension of name.
+func (m *material) add(name string, data []byte) error {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch ext {
+	case ".pdf":
+		info, err := extractPDF(data)
+		if err != nil {
+			return fmt.Errorf("PDF parse error: %w", err)
+		}
+		if len(info.Pages) == 0 {
+			return errors.New("no extractable text")
+		}
+		m.Docs = append(m.Docs, sourceDoc{Name: name, Pages: info.Pages})
+	case ".png", ".jpg", ".jpeg":
+		dataURL, err := imageBytesToDataURL(data, ext)
+		if err != nil {
+			return err
+		}
+		m.Images = append(m.Images, sourceImage{Name: name, DataURL: dataURL})
+	default:
+		return errors.New("unsupported file type, use PDF, PNG, JPG or JPEG")
+	}
+	return nil
+}
+
+// pdfInfo is what we extract from a PDF when it is added.
+type pdfInfo struct {
+	PageCount int
+	Pages     []pageText   // only pages with text
+	Sections  []pdfSection // from the outline (bookmarks), may be empty
+}
+
+// pdfSection is an outline entry with the pages it spans.
+type pdfSection struct {
+	Title     string
+	Level     int // 0 for top-level entries
+	FirstPage int
+	LastPage  int
+}
+
+// extractPDF returns the text of every page that has any, plus the outline.
+func extractPDF(data []byte) (*pdfInfo, error) {
+	rd := bytes.NewReader(data)
+	pdfReader, err := pdf.NewReader(rd, int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &pdfInfo{PageCount: pdfReader.NumPage()}
+	pageKeys := make(map[string]int, info.PageCount)
+	fonts := make(map[string]*pdf.Font)
+	for i := 1; i <= info.PageCount; i++ {
+		p := pdfReader.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+		pageKeys[p.V.String()] = i
+		for _, name := range p.Fonts() { // cache fonts so we don't continually parse charmap
+			if _, ok := fonts[name]; !ok {
+				f := p.Font(name)
+				fonts[name] = &f
+			}
+		}
+		text, err := p.GetPlainText(fonts)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", i, err)
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			info.Pages = append(info.Pages, pageText{Number: i, Text: text})
+		}
+	}
+
+	info.Sections = readOutline(pdfReader, pageKeys, info.PageCount)
+	return info, nil
+}
+
+// readOutline flattens the bookmarks into sections. pdf.Outline only has
+// titles, so destinations are resolved here by matching the target page
+// dictionary against the pages (keyed by their printed form, which includes
+// the unique object references of their contents).
+func readOutline(r *pdf.Reader, pageKeys map[string]int, pageCount int) []pdfSection {
+	root := r.Trailer().Key("Root")
+	var sections []pdfSection
+	visited := 0 // guards against cyclic outlines in broken files
+	var walk func(item pdf.Value, level int)
+	walk = func(item pdf.Value, level int) {
+		for ; item.Kind() == pdf.Dict && visited < 2000; item = item.Key("Next") {
+			visited++
+			page := destPage(root, outlineDest(item), pageKeys)
+			if title := strings.TrimSpace(item.Key("Title").Text()); title != "" && page > 0 {
+				sections = append(sections, pdfSection{Title: title, Level: level, FirstPage: page})
+			}
+			walk(item.Key("First"), level+1)
+		}
+	}
+	walk(root.Key("Outlines").Key("First"), 0)
+
+	// A section ends where the next one at the same or a higher level starts
+	for i := range sections {
+		sections[i].LastPage = pageCount
+		for j := i + 1; j < len(sections); j++ {
+			if sections[j].Level <= sections[i].Level {
+				sections[i].LastPage = sections[j].FirstPage - 1
+				break
+			}
+		}
+		if sections[i].LastPage < sections[i].FirstPage {
+			sections[i].LastPage = sections[i].FirstPage
+		}
+	}
+	return sections
+}
+
+func outlineDest(item pdf.Value) pdf.Value {
+	if d := item.Key("Dest"); !d.IsNull() {
+		return d
+	}
+	if a := item.Key("A"); a.Key("S").Name() == "GoTo" {
+		return a.Key("D")
+	}
+	return pdf.Value{}
+}
+
+// destPage resolves an explicit ([page /XYZ ...]) or named destination.
+func destPage(root, dest pdf.Value, pageKeys map[string]int) int {
+	switch dest.Kind() {
+	case pdf.Array:
+		if dest.Len() > 0 {
+			return pageKeys[dest.Index(0).String()]
+		}
+	case pdf.Dict:
+		return destPage(root, dest.Key("D"), pageKeys)
+	case pdf.Name, pdf.String:
+		key := dest.Name()
+		if dest.Kind() == pdf.String {
+			key = dest.RawString()
+		}
+		if d := root.Key("Dests").Key(key); !d.IsNull() {
+			return destPage(root, d, pageKeys)
+		}
+		if d := lookupNameTree(root.Key("Names").Key("Dests"), key, 0); !d.IsNull() {
+			return destPage(root, d, pageKeys)
+		}
+	}
+	return 0
+}
+
+func lookupNameTree(node pdf.Value, key string, depth int) pdf.Value {
+	if node.Kind() != pdf.Dict || depth > 32 {
+		return pdf.Value{}
+	}
+	names := node.Key("Names")
+	for i := 0; i+1 < names.Len(); i += 2 {
+		if names.Index(i).RawString() == key {
+			return names.Index(i + 1)
+		}
+	}
+	kids := node.Key("Kids")
+	for i := 0; i < kids.Len(); i++ {
+		if v := lookupNameTree(kids.Index(i), key, depth+1); !v.IsNull() {
+			return v
+		}
+	}
+	return pdf.Value{}
+}
+
+// parsePageRanges reads a selection like "1-5, 8, 10-12" into a sorted list
+// of distinct page numbers between 1 and max. An empty spec selects all pages.
+func parsePageRanges(spec string, max int) ([]int, error) {
+	selected := make([]bool, max+1)
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		for i := 1; i <= max; i++ {
+			selected[i] = true
+		}
+	}
+	for _, part := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ';' }) {
+		// Spaces only pad the numbers, as in "1 - 5"
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		a, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("invalid page %q", part)
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(strings.TrimSpace(to)); err != nil {
+				return nil, fmt.Errorf("invalid page range %q", part)
+			}
+		}
+		if a > b {
+			a, b = b, a
+		}
+		if a < 1 || b > max {
+			return nil, fmt.Errorf("page range %q outside 1-%d", part, max)
+		}
+		for i := a; i <= b; i++ {
+			selected[i] = true
+		}
+	}
+
+	var pages []int
+	for i := 1; i <= max; i++ {
+		if selected[i] {
+			pages = append(pages, i)
+		}
+	}
+	return pages, nil
+}
+
+// formatPageRanges is the inverse of parsePageRanges for sorted input.
+func formatPageRanges(pages []int) string {
+	var parts []string
+	for i := 0; i < len(pages); {
+		j := i
+		for j+1 < len(pages) && pages[j+1] == pages[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(pages[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", pages[i], pages[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// filterPages keeps only the pages whose number is in keep.
+func filterPages(pages []pageText, keep []int) []pageText {
+	want := make(map[int]bool, len(keep))
+	for _, n := range keep {
+		want[n] = true
+	}
+	var out []pageText
+	for _, p := range pages {
+		if want[p.Number] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// pageMarker is put in front of each page in the prompt; the model is asked
+// to copy file name and page number from it into every question.
+func pageMarker(file string, page int) string {
+	return fmt.Sprintf("[[File: %s | Pagina: %d]]", file, page)
+}
+
+// chunkMaterial lays out the documents page by page, each page preceded by
+// its marker, and packs the pages into chunks of at most size bytes. A page
+// too long for one chunk is split and its marker repeated on every piece.
+func chunkMaterial(docs []sourceDoc, size int) []string {
+	var segments []string
+	for _, d := range docs {
+		for _, p := range d.Pages {
+			marker := pageMarker(d.Name, p.Number) + "\n"
+			for _, piece := range splitIntoChunks(p.Text, size-len(marker)) {
+				segments = append(segments, marker+piece)
+			}
+		}
+	}
+
+	var chunks []string
+	var cur strings.Builder
+	for _, seg := range segments {
+		if cur.Len() > 0 && cur.Len()+2+len(seg) > size {
+			chunks = append(chunks, cur.String())
+			cur.Reset()
+		}
+		if cur.Len() > 0 {
+			cur.WriteString("\n\n")
+		}
+		cur.WriteString(seg)
+	}
+	if cur.Len() > 0 {
+		chunks = append(chunks, cur.String())
+	}
+	return chunks
+}
+
+// fixSources checks the citations the model produced against the material,
+// normalising file names and dropping page numbers that don't exist.
+func fixSources(qs *QuestionSet, m material) {
+	pagesOf := map[string]map[int]bool{}
+	var names []string
+	for _, d := range m.Docs {
+		pages := map[int]bool{}
+		for _, p := range d.Pages {
+			pages[p.Number] = true
+		}
+		pagesOf[d.Name] = pages
+		names = append(names, d.Name)
+	}
+	for _, img := range m.Images {
+		pagesOf[img.Name] = map[int]bool{}
+		names = append(names, img.Name)
+	}
+
+	for i := range qs.Questions {
+		src := &qs.Questions[i].Source
+		src.File = matchFileName(src.File, names)
+		if src.File == "" && len(names) == 1 {
+			src.File = names[0]
+		}
+		if src.Page > 0 && !pagesOf[src.File][src.Page] {
+			src.Page = 0
+		}
+	}
+}
+
+// matchFileName maps what the model wrote to one of the known names.
+func matchFileName(got string, names []string) string {
+	got = strings.TrimSpace(got)
+	if got == "" {
+		return ""
+	}
+	for _, n := range names {
+		if strings.EqualFold(n, got) {
+			return n
+		}
+	}
+	for _, n := range names {
+		if strings.Contains(strings.ToLower(got), strings.ToLower(n)) || strings.Contains(strings.ToLower(n), strings.ToLower(got)) {
+			return n
+		}
+	}
+	return ""
+}
+
+// pageNumber extracts the first integer from values like 12, "12" or "p. 12".
+func pageNumber(v flexString) int {
+	s := string(v)
+	start := strings.IndexFunc(s, unicode.IsDigit)
+	if start < 0 {
+		return 0
+	}
+	end := start
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[start:end])
+	return n
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePageRanges(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []int
+		wantErr bool
+	}{
+		{spec: "", want: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		{spec: "1-5, 8, 10-12", want: []int{1, 2, 3, 4, 5, 8, 10, 11, 12}},
+		{spec: "1 - 5", want: []int{1, 2, 3, 4, 5}},
+		{spec: " 3 ;7- 8 ", want: []int{3, 7, 8}},
+		{spec: "5-3", want: []int{3, 4, 5}},
+		{spec: "2, 1-3, 2", want: []int{1, 2, 3}},
+		{spec: "4,,6,", want: []int{4, 6}},
+		{spec: "12", want: []int{12}},
+		{spec: "0", wantErr: true},
+		{spec: "10-13", wantErr: true},
+		{spec: "1 5", wantErr: true},
+		{spec: "1-", wantErr: true},
+		{spec: "uno", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parsePageRanges(tt.spec, 12)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsePageRanges(%q) = %v, want an error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("parsePageRanges(%q) = %v, %v, want %v", tt.spec, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatPageRanges(t *testing.T) {
+	tests := []struct {
+		pages []int
+		want  string
+	}{
+		{nil, ""},
+		{[]int{4}, "4"},
+		{[]int{1, 2, 3, 4, 5, 8, 10, 11, 12}, "1-5, 8, 10-12"},
+		{[]int{1, 3, 5}, "1, 3, 5"},
+	}
+	for _, tt := range tests {
+		got := formatPageRanges(tt.pages)
+		if got != tt.want {
+			t.Errorf("formatPageRanges(%v) = %q, want %q", tt.pages, got, tt.want)
+		}
+		if len(tt.pages) == 0 {
+			continue
+		}
+		if back, err := parsePageRanges(got, 12); err != nil || !slices.Equal(back, tt.pages) {
+			t.Errorf("parsePageRanges(%q) = %v, %v, want %v", got, back, err, tt.pages)
+		}
+	}
+}
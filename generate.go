@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Question styles offered in the UI; the empty style is the standard one.
+const (
+	styleMultipleChoice = "Scelta multipla"
+	styleCloze          = "Completamento"
+	styleTrueFalse      = "Vero o Falso"
+	styleSequence       = "Sequenziale"
+	styleMatching       = "Abbinamento"
+	styleComplex        = "Complicate"
+	styleEssay          = "Tema con griglia"
+	styleNumbers        = "Date e numeri"
+)
+
+var questionStyles = []string{styleMultipleChoice, styleCloze, styleTrueFalse, styleSequence, styleMatching, styleComplex, styleEssay, styleNumbers}
+
+// styleLabel names a style for the user; the empty style is "Standard".
+func styleLabel(style string) string {
+	if style == "" {
+		return "Standard"
+	}
+	return style
+}
+
+// styleShare is how many questions of one style a mixed set has.
+type styleShare struct {
+	Style string `json:"style"`
+	N     int    `json:"n"`
+}
+
+// mixTotal is the number of questions of a mixed set.
+func mixTotal(mix []styleShare) int {
+	total := 0
+	for _, sh := range mix {
+		total += sh.N
+	}
+	return total
+}
+
+// mixText describes a mixed set, as in "4 Scelta multipla + 1 Standard".
+func mixText(mix []styleShare) string {
+	parts := make([]string, len(mix))
+	for i, sh := range mix {
+		parts[i] = fmt.Sprintf("%d %s", sh.N, styleLabel(sh.Style))
+	}
+	return strings.Join(parts, " + ")
+}
+
+// generationOptions are the choices that shape one generation.
+type generationOptions struct {
+	Model     string
+	Fallbacks []string // tried in order when Model fails or its output is unusable
+	N         int
+	Style     string // one of questionStyles, or empty for standard questions
+	Language  string // language code or name; empty means Italian
+	// Mix, when set, asks for a mixed set with this many questions of each
+	// style, in this order; N is then their total and Style is ignored.
+	Mix []styleShare
+	// ContextLength is the context window in tokens of the smallest model
+	// in the chain, or 0 if unknown; it sizes the material sent per request.
+	ContextLength int
+	// OnProgress, when set, is called as the reply streams in. Calls come
+	// from the generating goroutines, one at a time.
+	OnProgress func(generationProgress)
+
+	// seen holds the words of the questions already in a mixed set, so
+	// that a share doesn't repeat an earlier one (see uniqueQuestions)
+	seen *[][]string
+}
+
+// generationProgress is what has arrived so far of a streamed generation.
+type generationProgress struct {
+	Questions []Question // complete questions, not yet deduplicated or numbered
+	Tokens    int        // streamed pieces of text, roughly one token each
+}
+
+// languageNames maps the language codes accepted on the command line to the
+// name used in the (Italian) prompt.
+var languageNames = map[string]string{
+	"it": "italiano",
+	"en": "inglese",
+	"fr": "francese",
+	"de": "tedesco",
+	"es": "spagnolo",
+	"pt": "portoghese",
+	"nl": "olandese",
+}
+
+// languageName returns the prompt name for a language code; anything else
+// is passed through so that "latino" or "English" work too.
+func languageName(lang string) string {
+	lang = strings.TrimSpace(lang)
+	if lang == "" {
+		return languageNames["it"]
+	}
+	if name, ok := languageNames[strings.ToLower(lang)]; ok {
+		return name
+	}
+	return lang
+}
+
+// generateQuestionSet asks the model for opts.N questions in the given style
+// and returns them as a QuestionSet. Material that doesn't fit in the
+// model's context (see inputBudget), or more questions than fit in one
+// reply, are split into requests that are processed separately and merged
+// (see chunking.go). A set with fewer than opts.N questions, because the
+// model repeated itself, says how many were requested.
+// The usage covers every request made, including failed ones.
+func generateQuestionSet(ctx context.Context, p Provider, opts generationOptions, mat material) (*QuestionSet, Usage, error) {
+	if len(opts.Mix) > 0 {
+		return generateMixed(ctx, p, opts, mat)
+	}
+	chunks := materialChunks(opts, mat)
+
+	var qs *QuestionSet
+	var usage Usage
+	var err error
+	if len(chunks) <= 1 && opts.N <= questionsPerRequest(opts.Style) {
+		qs, usage, err = generateChunk(ctx, p, opts, strings.Join(chunks, ""), mat.Images)
+		if err == nil {
+			// Models don't always stop at n
+			qs.Questions = mergeBalanced([][]Question{qs.Questions}, opts.N, opts.seen)
+			qs.Renumber()
+		}
+	} else {
+		qs, usage, err = generateMapReduce(ctx, p, opts, chunks, mat.Images)
+	}
+	if err != nil {
+		return nil, usage, err
+	}
+	if len(qs.Questions) < opts.N {
+		qs.Requested = opts.N
+	}
+	fixSources(qs, mat)
+	qs.ShuffleChoices()
+	qs.Style = opts.Style
+	if qs.Model == "" {
+		qs.Model = opts.Model
+	}
+	qs.CreatedAt = time.Now()
+	qs.Usage = &usage
+	return qs, usage, nil
+}
+
+// generateMixed generates each share of a mixed set in turn, as a set of
+// its own, and merges them in the order of the mix. A question that repeats
+// one of an earlier share is dropped.
+func generateMixed(ctx context.Context, p Provider, opts generationOptions, mat material) (*QuestionSet, Usage, error) {
+	merged := &QuestionSet{Style: mixText(opts.Mix)}
+	var usage Usage
+	var seen [][]string
+	opts.seen = &seen
+	tokens := 0
+	for _, sh := range opts.Mix {
+		o := opts
+		o.Mix, o.Style, o.N = nil, sh.Style, sh.N
+		if opts.OnProgress != nil {
+			done, base := merged.Questions, tokens
+			o.OnProgress = func(pr generationProgress) {
+				tokens = base + pr.Tokens
+				opts.OnProgress(generationProgress{Questions: append(append([]Question(nil), done...), pr.Questions...), Tokens: tokens})
+			}
+		}
+		qs, used, err := generateQuestionSet(ctx, p, o, mat)
+		usage.Add(used)
+		if err != nil {
+			return nil, usage, fmt.Errorf("%s: %w", styleLabel(sh.Style), err)
+		}
+		merged.Questions = append(merged.Questions, qs.Questions...)
+		if qs.Skipped > merged.Skipped {
+			merged.Parts, merged.Skipped = qs.Parts, qs.Skipped
+		}
+		if merged.Model == "" {
+			merged.Model = qs.Model
+		}
+	}
+	if total := mixTotal(opts.Mix); len(merged.Questions) < total {
+		merged.Requested = total
+	}
+	merged.Renumber()
+	merged.CreatedAt = time.Now()
+	merged.Usage = &usage
+	return merged, usage, nil
+}
+
+// generateChunk asks the model for opts.N questions about a single piece of
+// material that fits in one request.
+func generateChunk(ctx context.Context, p Provider, opts generationOptions, mergedText string, images []sourceImage) (*QuestionSet, Usage, error) {
+	n := opts.N
+	lang := languageName(opts.Language)
+	langUpper := strings.ToUpper(lang)
+	// Build content parts
+	var parts []contentPart
+	var b strings.Builder
+
+	// Different prompts based on question style
+	qType := typeOpen
+	switch opts.Style {
+	case styleMultipleChoice:
+		qType = typeMultipleChoice
+		fmt.Fprintf(&b, "Istruzioni:\n- Estrai i punti principali dal materiale fornito.\n- Produci esattamente %d domande A SCELTA MULTIPLA IN %s.\n", n, langUpper)
+		b.WriteString("- \"choices\" contiene 4 o 5 opzioni, esattamente una con \"correct\": true; lascia \"options\" vuoto.\n")
+		b.WriteString("- Le opzioni errate sono distrattori plausibili (errori tipici, concetti vicini, confusioni frequenti), simili per lunghezza e stile alla risposta corretta; evita \"tutte le precedenti\" e \"nessuna delle precedenti\".\n")
+		b.WriteString("- \"rationale\" spiega per ogni opzione perché è corretta o perché è sbagliata.\n- \"answer\" è il testo dell'opzione corretta.\n")
+	case styleCloze:
+		qType = typeCloze
+		fmt.Fprintf(&b, "Istruzioni:\n- Scegli frasi importanti dal materiale fornito.\n- Produci esattamente %d domande DI COMPLETAMENTO IN %s.\n", n, langUpper)
+		fmt.Fprintf(&b, "- \"text\" è una frase del materiale, al più riformulata di poco, in cui da 1 a 3 termini chiave (concetti, nomi, date) sono sostituiti ciascuno da \"%s\"; non togliere parole generiche.\n", blankMarker)
+		b.WriteString("- \"blanks\" elenca i termini tolti nell'ordine in cui compaiono: \"answer\" è il termine esatto, \"alternatives\" i sinonimi e le grafie ugualmente corrette (anche nessuno).\n")
+		b.WriteString("- \"answer\" riporta i termini tolti separati da \"; \".\n")
+	case styleTrueFalse:
+		qType = typeTrueFalse
+		fmt.Fprintf(&b, "Istruzioni:\n- Estrai i punti principali dal materiale fornito.\n- Produci esattamente %d domande VERO o FALSO IN %s.\n", n, langUpper)
+		b.WriteString("- \"text\" è un'affermazione che può essere vera o falsa.\n- \"answer\" è \"Vero\" oppure \"Falso\"; \"explanation\" è una breve spiegazione.\n")
+	case styleSequence:
+		qType = typeSequence
+		fmt.Fprintf(&b, "Istruzioni:\n- Estrai eventi, processi o passaggi sequenziali dal materiale fornito.\n- Produci esattamente %d domande SEQUENZIALI IN %s che chiedono di mettere in ordine da 3 a 7 elementi.\n", n, langUpper)
+		b.WriteString("- \"text\" è la consegna, per esempio \"Metti in ordine cronologico i seguenti eventi\".\n")
+		b.WriteString("- \"sequence\" elenca gli elementi NELL'ORDINE CORRETTO, ognuno breve e senza numeri o date che rivelino la posizione; lascia \"options\" vuoto.\n")
+		b.WriteString("- \"answer\" è la sequenza corretta; \"explanation\" spiega il criterio dell'ordine.\n")
+	case styleMatching:
+		qType = typeMatching
+		fmt.Fprintf(&b, "Istruzioni:\n- Estrai dal materiale fornito coppie di elementi collegati: termine e definizione, causa ed effetto, autore e opera, evento e data.\n- Produci esattamente %d domande DI ABBINAMENTO IN %s, ognuna con da 4 a 6 coppie dello stesso tipo.\n", n, langUpper)
+		b.WriteString("- \"text\" è la consegna, per esempio \"Abbina ogni termine alla sua definizione\".\n")
+		b.WriteString("- \"pairs\" elenca le coppie corrette; gli elementi di destra sono tutti diversi tra loro e ciascuno corrisponde a un solo elemento di sinistra.\n")
+		b.WriteString("- \"answer\" riassume gli abbinamenti corretti; lascia \"options\" vuoto.\n")
+	case styleComplex:
+		fmt.Fprintf(&b, "Istruzioni:\n- Estrai concetti complessi e relazioni dal materiale fornito.\n- Produci esattamente %d domande COMPLESSE IN %s che richiedono analisi approfondita, confronto, o sintesi di più concetti.\n", n, langUpper)
+		b.WriteString("- \"answer\" è una risposta articolata e dettagliata.\n")
+	case styleEssay:
+		qType = typeEssay
+		fmt.Fprintf(&b, "Istruzioni:\n- Individua i temi principali del materiale fornito che si prestano a un ragionamento articolato.\n- Produci esattamente %d TRACCE DI TEMA IN %s, a cui rispondere con qualche paragrafo di argomentazione.\n", n, langUpper)
+		b.WriteString("- \"text\" è la traccia; \"key_points\" elenca da 3 a 6 punti chiave che una buona risposta deve toccare.\n")
+		b.WriteString("- \"rubric\" è la griglia di valutazione: da 3 a 5 criteri (per esempio contenuti, argomentazione, lessico specifico), ognuno con da 3 a 4 livelli dal migliore al peggiore; \"points\" è il punteggio del livello e \"description\" dice cosa deve fare la risposta per ottenerlo.\n")
+		b.WriteString("- I punteggi dei livelli migliori di tutti i criteri sommano a 10; il livello peggiore di ogni criterio vale 0.\n")
+		b.WriteString("- \"answer\" è lo schema di una risposta modello, in poche righe.\n")
+	case styleNumbers:
+		qType = typeNumeric
+		fmt.Fprintf(&b, "Istruzioni:\n- Estrai date, numeri, statistiche e dati numerici specifici dal materiale fornito.\n- Produci esattamente %d domande IN %s incentrate su DATE e NUMERI.\n", n, langUpper)
+		b.WriteString("- Ogni domanda ha come risposta un solo valore: un anno, una data, un numero (con l'unità di misura) o una percentuale.\n")
+		b.WriteString("- \"numeric\" è il valore tipizzato: \"kind\" è \"year\", \"date\", \"number\" o \"percent\"; \"value\" è l'anno, il numero o la percentuale (per 42% scrivi 42; 0 per le date); \"date\" è la data come AAAA-MM-GG (vuota se non è una data); \"unit\" è l'unità di misura dei numeri (vuota altrimenti).\n")
+		b.WriteString("- \"tolerance\" è lo scarto accettabile in una risposta a memoria, nella stessa unità (in giorni per le date): 0 per anni e date precise, di più per valori approssimati o stime, come 5 per una popolazione di circa 100 milioni.\n")
+		b.WriteString("- \"answer\" è il valore scritto per esteso, per esempio \"1789\", \"14 luglio 1789\", \"42%\" o \"8848 m\".\n")
+	default:
+		// Standard format
+		fmt.Fprintf(&b, "Istruzioni:\n- Estrai i punti principali dal materiale fornito.\n- Produci esattamente %d domande IN %s con le relative risposte.\n", n, langUpper)
+	}
+
+	b.WriteString("- Rispondi SOLO con un oggetto JSON, senza testo prima o dopo, con questa struttura:\n")
+	fmt.Fprintf(&b, "{\"questions\": [{\"id\": 1, \"type\": \"%s\", \"text\": \"...\", \"options\": [], \"choices\": [], \"blanks\": [], \"pairs\": [], \"sequence\": [], \"numeric\": null, \"key_points\": [], \"rubric\": [], \"answer\": \"...\", \"explanation\": \"...\", \"difficulty\": \"media\", \"tags\": [\"...\"], \"source_file\": \"...\", \"source_page\": 1, \"source_quote\": \"...\"}]}\n", qType)
+	b.WriteString("- \"choices\" è usato solo dalle domande a scelta multipla, come {\"text\": \"...\", \"correct\": false, \"rationale\": \"...\"}; altrimenti è una lista vuota.\n")
+	b.WriteString("- \"blanks\" è usato solo dalle domande di completamento, come {\"answer\": \"...\", \"alternatives\": []}; altrimenti è una lista vuota.\n")
+	b.WriteString("- \"pairs\" è usato solo dalle domande di abbinamento, come {\"left\": \"...\", \"right\": \"...\"}; altrimenti è una lista vuota.\n")
+	b.WriteString("- \"sequence\" è usato solo dalle domande sequenziali; altrimenti è una lista vuota.\n")
+	b.WriteString("- \"numeric\" è usato solo dalle domande su date e numeri; altrimenti è null.\n")
+	b.WriteString("- \"key_points\" e \"rubric\" sono usati solo dai temi, con \"rubric\" come [{\"criterion\": \"...\", \"levels\": [{\"points\": 4, \"description\": \"...\"}]}]; altrimenti sono liste vuote.\n")
+	b.WriteString("- Il materiale è diviso in pagine, ognuna preceduta da un indicatore [[File: nome | Pagina: N]].\n")
+	b.WriteString("- \"source_file\" e \"source_page\" sono il file e la pagina da cui deriva la domanda, copiati dall'indicatore; per le immagini usa il nome del file e pagina 0.\n")
+	b.WriteString("- \"source_quote\" è la frase chiave o il titolo della sezione da cui deriva la domanda.\n")
+	b.WriteString("- \"difficulty\" è \"facile\", \"media\" o \"difficile\"; \"tags\" sono 1-3 parole chiave sull'argomento.\n")
+	fmt.Fprintf(&b, "- Scrivi TUTTO in %s, anche se il materiale è in un'altra lingua; lascia i nomi dei campi JSON e i valori di \"difficulty\" come indicato.\n- Usa SOLO informazioni dal materiale fornito.\n\n", lang)
+
+	if strings.TrimSpace(mergedText) != "" {
+		b.WriteString("Materiale di studio:\n")
+		b.WriteString(mergedText)
+	}
+	if len(images) > 0 {
+		b.WriteString("\n\nImmagini allegate, nell'ordine:\n")
+		for i, img := range images {
+			fmt.Fprintf(&b, "%d. %s\n", i+1, img.Name)
+		}
+	}
+
+	parts = append(parts, contentPart{Type: "text", Text: b.String()})
+
+	for _, img := range images {
+		parts = append(parts, contentPart{
+			Type:     "image_url",
+			ImageURL: &imageURL{URL: img.DataURL},
+		})
+	}
+
+	req := completionRequest{
+		System:        fmt.Sprintf("Sei un insegnante esperto. Genera domande e risposte in %s dal materiale fornito.", lang),
+		Parts:         parts,
+		Temperature:   0.2,
+		Schema:        questionsSchema(),
+		ContextLength: opts.ContextLength,
+	}
+
+	// Walk the fallback chain until a model produces usable questions. This
+	// is the only fallback mechanism, OpenRouter's "models" routing isn't
+	// used: a model would otherwise be tried, and billed, twice
+	models := append([]string{opts.Model}, opts.Fallbacks...)
+	var errs []error
+	var usage Usage
+	for _, model := range models {
+		req.Model = model
+		qs, used, err := askQuestions(ctx, p, req, opts.OnProgress)
+		// A reply that couldn't be used was still paid for
+		usage.Add(used)
+		if err == nil {
+			return qs, usage, nil
+		}
+		if len(models) > 1 {
+			err = fmt.Errorf("%s: %w", model, err)
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil || !worthFallback(err) {
+			break
+		}
+	}
+	return nil, usage, errors.Join(errs...)
+}
+
+// askQuestions sends one request and decodes the questions in the reply.
+// qs.Model is the model that actually answered.
+func askQuestions(ctx context.Context, p Provider, req completionRequest, onProgress func(generationProgress)) (*QuestionSet, Usage, error) {
+	// Always stream: a long generation then isn't cut off by the client
+	// timeout as long as the server keeps sending
+	var raw strings.Builder
+	tokens := 0
+	req.OnDelta = func(text string) {
+		raw.WriteString(text)
+		tokens++
+		// Only a closing brace can complete a question
+		if onProgress != nil && (strings.Contains(text, "}") || tokens%25 == 0) {
+			onProgress(generationProgress{Questions: partialQuestions(raw.String()), Tokens: tokens})
+		}
+	}
+	resp, err := completeStructured(ctx, p, req)
+	if err != nil {
+		return nil, resp.Usage, err
+	}
+	if resp.FinishReason == "content_filter" || resp.FinishReason == "refusal" {
+		return nil, resp.Usage, errRefused
+	}
+
+	gs, err := decodeQuestions(resp.Content)
+	if err != nil {
+		return nil, resp.Usage, err
+	}
+	qs := gs.toQuestionSet()
+	qs.Model = req.Model
+	if resp.Model != "" {
+		qs.Model = resp.Model
+	}
+	return qs, resp.Usage, nil
+}
+
+// errRefused is returned when the model declined to answer.
+var errRefused = errors.New("the model refused to generate questions")
+
+// worthFallback reports whether another model might succeed where this one
+// failed. A rejected API key or an exhausted budget fails for every model.
+func worthFallback(err error) bool {
+	var he *httpError
+	if errors.As(err, &he) {
+		switch he.Status {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusPaymentRequired:
+			return false
+		}
+	}
+	return true
+}
+
+// parseModelList splits a list of model IDs separated by commas, semicolons
+// or newlines, keeping the order and dropping duplicates.
+func parseModelList(text string) []string {
+	var models []string
+	seen := map[string]bool{}
+	for _, m := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ';' || r == '\n' }) {
+		m = strings.TrimSpace(m)
+		if m != "" && !seen[m] {
+			seen[m] = true
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// withPrimaryModel makes id the first model of a model list, keeping the
+// others as fallbacks.
+func withPrimaryModel(text, id string) string {
+	models := []string{id}
+	for _, m := range parseModelList(text) {
+		if m != id {
+			models = append(models, m)
+		}
+	}
+	return strings.Join(models, ", ")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeProvider answers every request with distinct open questions: as many
+// as the prompt asks for, or perReply when set.
+type fakeProvider struct {
+	perReply int
+
+	mu    sync.Mutex
+	calls int
+}
+
+var askedCount = regexp.MustCompile(`esattamente (\d+) domande`)
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) Complete(ctx context.Context, req completionRequest) (completionResponse, error) {
+	p.mu.Lock()
+	p.calls++
+	call := p.calls
+	p.mu.Unlock()
+
+	n := p.perReply
+	if n == 0 {
+		for _, part := range req.Parts {
+			if m := askedCount.FindStringSubmatch(part.Text); m != nil {
+				n, _ = strconv.Atoi(m[1])
+			}
+		}
+	}
+	var gs generatedSet
+	for i := 1; i <= n; i++ {
+		gs.Questions = append(gs.Questions, generatedQuestion{
+			Text:   fmt.Sprintf("Domanda%dx%d sul concetto%dx%d?", call, i, call, i),
+			Answer: flexString(fmt.Sprintf("Risposta%dx%d", call, i)),
+		})
+	}
+	data, err := json.Marshal(gs)
+	return completionResponse{Content: string(data)}, err
+}
+
+func TestWorthFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad key", &httpError{Status: http.StatusUnauthorized}, false},
+		{"forbidden", &httpError{Status: http.StatusForbidden}, false},
+		{"no credit", &httpError{Status: http.StatusPaymentRequired}, false},
+		{"no credit after retries", &retryError{Attempts: 3, Err: &httpError{Status: http.StatusPaymentRequired}}, false},
+		{"model named in the error", fmt.Errorf("openai/gpt-4o: %w", &httpError{Status: http.StatusUnauthorized}), false},
+		{"unknown model", &httpError{Status: http.StatusNotFound}, true},
+		{"overloaded", &httpError{Status: http.StatusServiceUnavailable}, true},
+		{"refused", errRefused, true},
+		{"unusable reply", errors.New("no questions generated"), true},
+		{"stalled stream", errStalled, true},
+		{"cancelled", context.Canceled, true}, // the caller checks the context first
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := worthFallback(tt.err); got != tt.want {
+				t.Errorf("worthFallback(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseModelList(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"openai/gpt-4o", []string{"openai/gpt-4o"}},
+		{" a , b;c\nd ", []string{"a", "b", "c", "d"}},
+		{"a, b, a", []string{"a", "b"}},
+		{",, ;\n", nil},
+	}
+	for _, tt := range tests {
+		if got := parseModelList(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("parseModelList(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestWithPrimaryModel(t *testing.T) {
+	tests := []struct {
+		text, id, want string
+	}{
+		{"", "a", "a"},
+		{"b, c", "a", "a, b, c"},
+		{"b, a, c", "a", "a, b, c"},
+		{"a", "a", "a"},
+	}
+	for _, tt := range tests {
+		if got := withPrimaryModel(tt.text, tt.id); got != tt.want {
+			t.Errorf("withPrimaryModel(%q, %q) = %q, want %q", tt.text, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateQuestionSetSplitsLargeRequests(t *testing.T) {
+	short := material{Docs: []sourceDoc{{Name: "appunti.pdf", Pages: []pageText{{Number: 1, Text: "La fotosintesi trasforma la luce in energia chimica."}}}}}
+	perRequest := questionsPerRequest("")
+	tests := []struct {
+		name          string
+		n             int
+		perReply      int
+		wantCalls     int
+		wantQuestions int
+		wantRequested int
+	}{
+		{"fits in one reply", perRequest, 0, 1, perRequest, 0},
+		{"several replies on the same material", 2 * perRequest, 0, 3, 2 * perRequest, 0},
+		{"shortfall is reported", 2 * perRequest, 10, 3, 30, 2 * perRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakeProvider{perReply: tt.perReply}
+			qs, _, err := generateQuestionSet(context.Background(), p, generationOptions{Model: "m", N: tt.n}, short)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.calls != tt.wantCalls || len(qs.Questions) != tt.wantQuestions || qs.Requested != tt.wantRequested {
+				t.Errorf("%d calls, %d questions, requested %d; want %d, %d, %d", p.calls, len(qs.Questions), qs.Requested, tt.wantCalls, tt.wantQuestions, tt.wantRequested)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 90 * time.Second
+
+// Environment variables override the saved preferences, so the same binary
+// can be pointed at a gateway or a mock server without touching the UI.
+const (
+	envBaseURL  = "LAZYQ_BASE_URL"
+	envProxy    = "LAZYQ_PROXY"
+	envHeaders  = "LAZYQ_HEADERS"
+	envTimeout  = "LAZYQ_TIMEOUT"
+	envCABundle = "LAZYQ_CA_BUNDLE"
+	envAPIKey   = "LAZYQ_API_KEY"
+	envAttempts = "LAZYQ_RETRIES"
+)
+
+// httpSettings controls how requests leave the app.
+type httpSettings struct {
+	ProxyURL string            // empty means HTTPS_PROXY/HTTP_PROXY from the environment
+	Headers  map[string]string // sent on every request, overriding the provider defaults
+	Timeout  time.Duration     // zero means defaultTimeout
+	CABundle string            // PEM file trusted in addition to the system roots
+	Attempts int               // tries per request for transient errors; zero means defaultAttempts
+}
+
+// client builds the http.Client for these settings.
+func (s httpSettings) client() (*http.Client, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+
+	if s.ProxyURL != "" {
+		pu, err := url.Parse(s.ProxyURL)
+		if err != nil || pu.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", s.ProxyURL)
+		}
+		tr.Proxy = http.ProxyURL(pu)
+	}
+
+	if s.CABundle != "" {
+		pem, err := os.ReadFile(s.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", s.CABundle)
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &http.Client{Timeout: timeout, Transport: tr}, nil
+}
+
+// parseHeaders reads "Name: value" pairs separated by newlines or ';'.
+func parseHeaders(text string) (map[string]string, error) {
+	headers := map[string]string{}
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ';' })
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(f, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", f)
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// parseTimeout accepts either a Go duration ("2m30s") or plain seconds ("150").
+func parseTimeout(text string) (time.Duration, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.Atoi(text); err == nil {
+		if secs < 0 {
+			return 0, fmt.Errorf("invalid timeout %q", text)
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid timeout %q", text)
+	}
+	return d, nil
+}
+
+// applyEnv overrides cfg with any LAZYQ_* variables that are set.
+func (cfg *providerConfig) applyEnv() error {
+	if v := os.Getenv(envBaseURL); v != "" {
+		cfg.BaseURL = v
+	}
+	if v := os.Getenv(envProxy); v != "" {
+		cfg.HTTP.ProxyURL = v
+	}
+	if v := os.Getenv(envHeaders); v != "" {
+		h, err := parseHeaders(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", envHeaders, err)
+		}
+		if cfg.HTTP.Headers == nil {
+			cfg.HTTP.Headers = map[string]string{}
+		}
+		for k, val := range h {
+			cfg.HTTP.Headers[k] = val
+		}
+	}
+	if v := os.Getenv(envTimeout); v != "" {
+		d, err := parseTimeout(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", envTimeout, err)
+		}
+		cfg.HTTP.Timeout = d
+	}
+	if v := os.Getenv(envCABundle); v != "" {
+		cfg.HTTP.CABundle = v
+	}
+	if v := strings.TrimSpace(os.Getenv(envAPIKey)); v != "" {
+		cfg.APIKey = v
+	}
+	if v := os.Getenv(envAttempts); v != "" {
+		n, err := parseAttempts(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", envAttempts, err)
+		}
+		cfg.HTTP.Attempts = n
+	}
+	return nil
+}
+
+// httpError is a non-2xx reply from a provider.
+type httpError struct {
+	Provider   string
+	Status     int
+	Body       string
+	RetryAfter time.Duration // from the Retry-After header, zero if absent
+}
+
+func newHTTPError(name string, resp *http.Response) *httpError {
+	body, _ := io.ReadAll(resp.Body)
+	return &httpError{
+		Provider:   name,
+		Status:     resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%s HTTP %d: %s", e.Provider, e.Status, truncate(e.Body, 500))
+}
+
+// endpoint is what a provider uses to talk to its server: the configured
+// client plus the headers that go on every request.
+type endpoint struct {
+	client  *http.Client
+	headers map[string]string
+	// Streamed replies can legitimately take longer than the client timeout,
+	// so they use a client without one and fail only when the server goes
+	// quiet for longer than idle.
+	streamClient *http.Client
+	idle         time.Duration
+}
+
+func newEndpoint(s httpSettings, defaults map[string]string) (endpoint, error) {
+	c, err := s.client()
+	if err != nil {
+		return endpoint{}, err
+	}
+	sc := *c
+	sc.Timeout = 0
+	headers := map[string]string{}
+	for k, v := range defaults {
+		headers[k] = v
+	}
+	for k, v := range s.Headers {
+		headers[k] = v
+	}
+	return endpoint{client: c, headers: headers, streamClient: &sc, idle: c.Timeout}, nil
+}
+
+func (e endpoint) setHeaders(req *http.Request, headers map[string]string) {
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	// Configured headers win over per-call ones such as Authorization
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+}
+
+func (e endpoint) do(name string, req *http.Request, headers map[string]string) ([]byte, error) {
+	e.setHeaders(req, headers)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, newHTTPError(name, resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// postJSON sends in as a JSON body and decodes the reply into out.
+func (e endpoint) postJSON(ctx context.Context, name, url string, headers map[string]string, in, out interface{}) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	body, err := e.do(name, httpReq, headers)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		// Try to surface raw body if unmarshalling fails
+		return fmt.Errorf("failed to decode response: %v\nRaw: %s", err, truncate(string(body), 800))
+	}
+	return nil
+}
+
+// postStream sends in as a JSON body and passes every line of the streamed
+// reply (server-sent events or NDJSON) to onLine. A server that ignores the
+// stream flag answers with a plain JSON body, which is returned instead.
+func (e endpoint) postStream(parent context.Context, name, url string, headers map[string]string, in interface{}, onLine func(line string) error) (plain []byte, err error) {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	timer := time.AfterFunc(e.idle, cancel)
+	defer timer.Stop()
+	// Unless the caller gave up, only the timer cancels ctx before we
+	// return: report the stall as such rather than as "context canceled"
+	defer func() {
+		if err != nil && ctx.Err() != nil && parent.Err() == nil {
+			err = fmt.Errorf("%s sent no data for %s: %w", name, e.idle, errStalled)
+		}
+	}()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	e.setHeaders(httpReq, headers)
+
+	resp, err := e.streamClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, newHTTPError(name, resp)
+	}
+	ct := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "text/event-stream") && !strings.HasPrefix(ct, "application/x-ndjson") {
+		return io.ReadAll(resp.Body)
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		timer.Reset(e.idle)
+		if err := onLine(sc.Text()); err != nil {
+			return nil, err
+		}
+	}
+	return nil, sc.Err()
+}
+
+// getJSON fetches url and decodes the reply into out.
+func (e endpoint) getJSON(ctx context.Context, name, url string, headers map[string]string, out interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	body, err := e.do(name, httpReq, headers)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response: %v\nRaw: %s", err, truncate(string(body), 800))
+	}
+	return nil
+}
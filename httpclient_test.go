@@ -0,0 +1,75 @@
+package main
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", text: "  \n ", want: map[string]string{}},
+		{name: "one", text: "X-Api-Key: abc", want: map[string]string{"X-Api-Key": "abc"}},
+		{name: "lines and semicolons", text: "x-a: 1\nX-B:2 ; x-c : 3", want: map[string]string{"X-A": "1", "X-B": "2", "X-C": "3"}},
+		{name: "blank lines", text: "\n\nX-A: 1\n\n", want: map[string]string{"X-A": "1"}},
+		{name: "colon in value", text: "Authorization: Basic a:b", want: map[string]string{"Authorization": "Basic a:b"}},
+		{name: "empty value", text: "X-Empty:", want: map[string]string{"X-Empty": ""}},
+		{name: "last one wins", text: "x-a: 1\nX-A: 2", want: map[string]string{"X-A": "2"}},
+		{name: "no colon", text: "X-A 1", wantErr: true},
+		{name: "no name", text: ": 1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaders(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseHeaders(%q) = %v, want an error", tt.text, got)
+				}
+				return
+			}
+			if err != nil || !maps.Equal(got, tt.want) {
+				t.Errorf("parseHeaders(%q) = %v, %v, want %v", tt.text, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{text: "", want: 0},
+		{text: "  ", want: 0},
+		{text: "90", want: 90 * time.Second},
+		{text: " 30 ", want: 30 * time.Second},
+		{text: "0", want: 0},
+		{text: "2m", want: 2 * time.Minute},
+		{text: "1m30s", want: 90 * time.Second},
+		{text: "1500ms", want: 1500 * time.Millisecond},
+		{text: "-5", wantErr: true},
+		{text: "-1s", wantErr: true},
+		{text: "1.5", wantErr: true},
+		{text: "dieci", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := parseTimeout(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseTimeout(%q) = %v, want an error", tt.text, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("parseTimeout(%q) = %v, %v, want %v", tt.text, got, err, tt.want)
+			}
+		})
+	}
+}
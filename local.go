@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// isLocalEndpoint reports whether rawURL points at this machine or at a
+// private network address, i.e. the material never leaves the school network.
+func isLocalEndpoint(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "" {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".local") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
+
+// listModels asks a server which models it can run. Ollama answers on
+// /api/tags; llama.cpp and other OpenAI-compatible servers on /models.
+func listModels(cfg providerConfig) ([]string, error) {
+	base := cfg.baseURL()
+	// Probing should fail fast rather than wait for the generation timeout
+	probe := cfg.HTTP
+	probe.Timeout = 15 * time.Second
+	ep, err := newEndpoint(probe, nil)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{}
+	if cfg.APIKey != "" {
+		headers["Authorization"] = "Bearer " + cfg.APIKey
+	}
+
+	var names []string
+	switch cfg.Kind {
+	case providerOllama:
+		var tags struct {
+			Models []struct {
+				Name string `json:"name"`
+			} `json:"models"`
+		}
+		if err := ep.getJSON(context.Background(), "Ollama", base+"/api/tags", nil, &tags); err != nil {
+			return nil, err
+		}
+		for _, m := range tags.Models {
+			names = append(names, m.Name)
+		}
+	case providerAnthropic:
+		return nil, fmt.Errorf("model listing is not supported for %s", providerLabel(cfg.Kind))
+	default:
+		var list struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := ep.getJSON(context.Background(), providerLabel(cfg.Kind), base+"/models", headers, &list); err != nil {
+			return nil, err
+		}
+		for _, m := range list.Data {
+			names = append(names, m.ID)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
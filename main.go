@@ -1,14 +1,13 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -22,26 +21,40 @@ import (
 	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-	pdf "github.com/ledongthuc/pdf"
 )
 
 //go:embed internal/logoNoBackgorund.png
 var logoData []byte
 
 const (
-	appID         = "lazyq"
-	appTitle      = "LazyQ"
-	defaultModel  = "openai/gpt-4o" // Puoi cambiare in "openai/gpt-5" se disponibile sul tuo account OpenRouter
-	maxTextChars  = 12000           // sicurezza per mantenere le dimensioni del payload ragionevoli
-	openRouterURL = "https://openrouter.ai/api/v1/chat/completions"
-	prefAPIKey    = "openrouter_api_key"
-	prefModel     = "openrouter_model"
-	defaultN      = 10
-	refererHeader = "https://local-app/lazyq"
-	xTitleHeader  = "LazyQ"
+	appID             = "lazyq"
+	appTitle          = "LazyQ"
+	defaultModel      = "openai/gpt-4o" // Puoi cambiare in "openai/gpt-5" se disponibile sul tuo account OpenRouter
+	openRouterBaseURL = "https://openrouter.ai/api/v1"
+	prefAPIKey        = "api_key"            // one per provider, see apiKeyPref
+	prefLegacyAPIKey  = "openrouter_api_key" // the single key saved by older versions
+	prefModel         = "openrouter_model"
+	prefProvider      = "llm_provider"
+	prefBaseURL       = "llm_base_url"
+	prefProxy         = "http_proxy"
+	prefHeaders       = "http_headers"
+	prefTimeout       = "http_timeout"
+	prefCABundle      = "http_ca_bundle"
+	prefAttempts      = "http_retries"
+	prefBudgetDay     = "budget_day"
+	prefBudgetMonth   = "budget_month"
+	defaultN          = 10
+	styleMixedLabel   = "Misto" // style radio entry for a mixed set
+	refererHeader     = "https://local-app/lazyq"
+	xTitleHeader      = "LazyQ"
 )
 
 func main() {
+	// Headless subcommands (see cli.go) never open a window
+	if code, ok := runCommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
 	a := app.NewWithID(appID)
 
 	// Set app icon from embedded data
@@ -60,11 +73,10 @@ func main() {
 		w.SetContent(container.NewMax(co))
 	}
 
-	// Check if API key exists
+	// Check if the provider is already configured
 	prefs := a.Preferences()
-	existingKey := strings.TrimSpace(prefs.String(prefAPIKey))
 
-	if existingKey != "" {
+	if isConfigured(prefs) {
 		// Skip greet and key screen, go directly to main
 		setContent(createMainScreen(a, w, func() {
 			setContent(createAPIKeyScreen(a, w, func() {
@@ -84,9 +96,80 @@ func main() {
 	w.ShowAndRun()
 }
 
+// isConfigured reports whether the saved preferences are enough to build a provider.
+func isConfigured(prefs fyne.Preferences) bool {
+	_, err := providerFromPrefs(prefs)
+	return err == nil
+}
+
+// providerConfigFromPrefs reads the saved provider settings, with any
+// LAZYQ_* environment variables taking precedence.
+func providerConfigFromPrefs(prefs fyne.Preferences) (providerConfig, error) {
+	cfg := providerConfig{
+		Kind:    prefs.StringWithFallback(prefProvider, providerOpenRouter),
+		BaseURL: prefs.String(prefBaseURL),
+		APIKey:  savedAPIKey(prefs, prefs.StringWithFallback(prefProvider, providerOpenRouter)),
+		HTTP: httpSettings{
+			ProxyURL: strings.TrimSpace(prefs.String(prefProxy)),
+			CABundle: strings.TrimSpace(prefs.String(prefCABundle)),
+		},
+	}
+	var err error
+	if cfg.HTTP.Headers, err = parseHeaders(prefs.String(prefHeaders)); err != nil {
+		return cfg, err
+	}
+	if cfg.HTTP.Timeout, err = parseTimeout(prefs.String(prefTimeout)); err != nil {
+		return cfg, err
+	}
+	if cfg.HTTP.Attempts, err = parseAttempts(prefs.String(prefAttempts)); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.applyEnv()
+}
+
+// budgetFromPrefs reads the saved spending caps, with the LAZYQ_BUDGET_*
+// environment variables taking precedence.
+func budgetFromPrefs(prefs fyne.Preferences) (budget, error) {
+	var b budget
+	var err error
+	if b.Day, err = parseBudget(prefs.String(prefBudgetDay)); err != nil {
+		return b, err
+	}
+	if b.Month, err = parseBudget(prefs.String(prefBudgetMonth)); err != nil {
+		return b, err
+	}
+	return b, b.applyEnv()
+}
+
+func providerFromPrefs(prefs fyne.Preferences) (Provider, error) {
+	cfg, err := providerConfigFromPrefs(prefs)
+	if err != nil {
+		return nil, err
+	}
+	return newProvider(cfg)
+}
+
+// apiKeyPref is the preference holding the API key of a provider kind, so
+// switching provider doesn't overwrite the key of the previous one.
+func apiKeyPref(kind string) string {
+	return prefAPIKey + "_" + kind
+}
+
+// savedAPIKey returns the saved key of a provider kind. Older versions kept
+// one key for every provider; it still belongs to the provider saved with it.
+func savedAPIKey(prefs fyne.Preferences, kind string) string {
+	if key := strings.TrimSpace(prefs.String(apiKeyPref(kind))); key != "" {
+		return key
+	}
+	if kind == prefs.StringWithFallback(prefProvider, providerOpenRouter) {
+		return strings.TrimSpace(prefs.String(prefLegacyAPIKey))
+	}
+	return ""
+}
+
 func createGreetScreen(w fyne.Window, onNext func()) fyne.CanvasObject {
 	title := widget.NewLabelWithStyle("Benvenuto al Generatore di Test per Studenti", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
-	sub := widget.NewLabel("Genera domande di studio dai tuoi PDF e immagini. Continua per inserire la tua chiave API di OpenRouter.")
+	sub := widget.NewLabel("Genera domande di studio dai tuoi PDF e immagini. Continua per scegliere il provider e, se serve, inserire la tua chiave API.")
 
 	btn := widget.NewButtonWithIcon("Continua", theme.NavigateNextIcon(), func() {
 		onNext()
@@ -103,10 +186,13 @@ func createGreetScreen(w fyne.Window, onNext func()) fyne.CanvasObject {
 
 func createAPIKeyScreen(a fyne.App, w fyne.Window, onNext func()) fyne.CanvasObject {
 	prefs := a.Preferences()
-	existing := prefs.String(prefAPIKey)
+	kind := prefs.StringWithFallback(prefProvider, providerOpenRouter)
+	existing := savedAPIKey(prefs, kind)
+	// Keys typed for the other providers survive switching back and forth
+	keys := map[string]string{kind: existing}
 	modelExisting := prefs.String(prefModel)
 	if modelExisting == "" {
-		modelExisting = defaultModel
+		modelExisting = defaultModelFor(kind)
 	}
 
 	info := widget.NewLabel("Inserisci la tua chiave API di OpenRouter. Sarà salvata localmente nelle preferenze dell'app.")
@@ -116,8 +202,258 @@ func createAPIKeyScreen(a fyne.App, w fyne.Window, onNext func()) fyne.CanvasObj
 
 	modelLabel := widget.NewLabel("Modello (ID OpenRouter):")
 	modelEntry := widget.NewEntry()
-	modelEntry.SetPlaceHolder(defaultModel)
+	modelEntry.SetPlaceHolder(defaultModelFor(kind))
 	modelEntry.SetText(modelExisting)
+	pickModelBtn := widget.NewButtonWithIcon("", theme.SearchIcon(), nil) // wired below, once the network settings exist
+	fallbackHint := widget.NewLabel("Puoi indicare più modelli separati da virgola: se il primo non risponde o restituisce un risultato non valido si passa al successivo.")
+	fallbackHint.Wrapping = fyne.TextWrapWord
+
+	// Provider selection; the endpoint is only needed when not using the default
+	endpointLabel := widget.NewLabel("Endpoint (opzionale):")
+	endpointEntry := widget.NewEntry()
+	endpointEntry.SetPlaceHolder(defaultBaseURL(kind))
+	endpointEntry.SetText(prefs.String(prefBaseURL))
+
+	// Local mode: no key, the endpoint must be on this machine or the school network
+	localCheck := widget.NewCheck("Nessuna chiave, endpoint locale", nil)
+	localCheck.SetChecked(!providerNeedsKey(kind) || (strings.TrimSpace(existing) == "" && isLocalEndpoint(prefs.String(prefBaseURL))))
+
+	applyKind := func() {
+		modelEntry.SetPlaceHolder(defaultModelFor(kind))
+		if kind == providerAnthropic {
+			pickModelBtn.Hide()
+		} else {
+			pickModelBtn.Show()
+		}
+		endpointEntry.SetPlaceHolder(defaultBaseURL(kind))
+		if kind == providerOpenRouter {
+			info.SetText("Inserisci la tua chiave API di OpenRouter. Sarà salvata localmente nelle preferenze dell'app.")
+			modelLabel.SetText("Modello (ID OpenRouter):")
+		} else {
+			info.SetText(fmt.Sprintf("Inserisci la tua chiave API di %s. Sarà salvata localmente nelle preferenze dell'app.", providerLabel(kind)))
+			modelLabel.SetText("Modello:")
+		}
+		if !providerNeedsKey(kind) {
+			info.SetText(fmt.Sprintf("%s gira in locale: nessuna chiave API necessaria, il materiale non lascia la rete.", providerLabel(kind)))
+		}
+		if localCheck.Checked || !providerNeedsKey(kind) {
+			entry.Disable()
+		} else {
+			entry.Enable()
+		}
+	}
+
+	var labels []string
+	for _, k := range providerKinds {
+		labels = append(labels, providerLabel(k))
+	}
+	providerSelect := widget.NewSelect(labels, func(label string) {
+		newKind := providerKindFromLabel(label)
+		if newKind == kind {
+			return
+		}
+		// Swap defaults only if the user hadn't typed their own values
+		if strings.TrimSpace(modelEntry.Text) == defaultModelFor(kind) {
+			modelEntry.SetText(defaultModelFor(newKind))
+		}
+		if strings.TrimSpace(endpointEntry.Text) == defaultBaseURL(kind) {
+			endpointEntry.SetText("")
+		}
+		keys[kind] = entry.Text
+		kind = newKind
+		if key, ok := keys[kind]; ok {
+			entry.SetText(key)
+		} else {
+			entry.SetText(savedAPIKey(prefs, kind))
+		}
+		if !providerNeedsKey(kind) {
+			localCheck.SetChecked(true)
+		}
+		applyKind()
+	})
+	providerSelect.SetSelected(providerLabel(kind))
+	localCheck.OnChanged = func(checked bool) {
+		if checked && providerNeedsKey(kind) && !isLocalEndpoint(endpointEntry.Text) {
+			// A cloud provider can't be used without a key; default to Ollama
+			providerSelect.SetSelected(providerLabel(providerOllama))
+		}
+		applyKind()
+	}
+	applyKind()
+
+	// Advanced network settings, for institutional gateways and mock servers
+	proxyEntry := widget.NewEntry()
+	proxyEntry.SetPlaceHolder("http://proxy.scuola.it:3128")
+	proxyEntry.SetText(prefs.String(prefProxy))
+
+	headersEntry := widget.NewMultiLineEntry()
+	headersEntry.SetPlaceHolder("Nome-Header: valore (uno per riga)")
+	headersEntry.SetText(prefs.String(prefHeaders))
+	headersEntry.SetMinRowsVisible(3)
+
+	timeoutEntry := widget.NewEntry()
+	timeoutEntry.SetPlaceHolder(fmt.Sprintf("%d", int(defaultTimeout.Seconds())))
+	timeoutEntry.SetText(prefs.String(prefTimeout))
+
+	attemptsEntry := widget.NewEntry()
+	attemptsEntry.SetPlaceHolder(fmt.Sprintf("%d", defaultAttempts))
+	attemptsEntry.SetText(prefs.String(prefAttempts))
+
+	caEntry := widget.NewEntry()
+	caEntry.SetPlaceHolder("Percorso file PEM (opzionale)")
+	caEntry.SetText(prefs.String(prefCABundle))
+	caBrowse := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
+		fd := dialog.NewFileOpen(func(r fyne.URIReadCloser, err error) {
+			if err != nil || r == nil {
+				return
+			}
+			defer r.Close()
+			caEntry.SetText(r.URI().Path())
+		}, w)
+		fd.SetFilter(storage.NewExtensionFileFilter([]string{".pem", ".crt", ".cer"}))
+		fd.Show()
+	})
+
+	readNetwork := func() (httpSettings, error) {
+		hs := httpSettings{
+			ProxyURL: strings.TrimSpace(proxyEntry.Text),
+			CABundle: strings.TrimSpace(caEntry.Text),
+		}
+		var err error
+		if hs.Headers, err = parseHeaders(headersEntry.Text); err != nil {
+			return hs, err
+		}
+		if hs.Timeout, err = parseTimeout(timeoutEntry.Text); err != nil {
+			return hs, err
+		}
+		if hs.Attempts, err = parseAttempts(attemptsEntry.Text); err != nil {
+			return hs, err
+		}
+		// Fail early on a bad proxy or CA file rather than at generation time
+		_, err = hs.client()
+		return hs, err
+	}
+
+	networkForm := container.NewVBox(
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Proxy HTTP:"), proxyEntry,
+			widget.NewLabel("Timeout (secondi):"), timeoutEntry,
+			widget.NewLabel("Tentativi per richiesta:"), attemptsEntry,
+		),
+		widget.NewLabel("Header aggiuntivi:"),
+		headersEntry,
+		widget.NewLabel("Certificati CA aggiuntivi:"),
+		container.NewBorder(nil, nil, nil, caBrowse, caEntry),
+		widget.NewLabel(fmt.Sprintf("Le variabili d'ambiente %s, %s, %s, %s, %s e %s hanno la precedenza su questi valori.", envBaseURL, envProxy, envHeaders, envTimeout, envAttempts, envCABundle)),
+	)
+
+	// Spending caps and the price list used to estimate each run
+	budgetDayEntry := widget.NewEntry()
+	budgetDayEntry.SetPlaceHolder("nessun limite")
+	budgetDayEntry.SetText(prefs.String(prefBudgetDay))
+	budgetMonthEntry := widget.NewEntry()
+	budgetMonthEntry.SetPlaceHolder("nessun limite")
+	budgetMonthEntry.SetText(prefs.String(prefBudgetMonth))
+
+	pricesLabel := widget.NewLabel("")
+	showPricesDate := func(t priceTable) {
+		if t.Updated.IsZero() {
+			pricesLabel.SetText("Listino prezzi: valori indicativi incorporati.")
+		} else {
+			pricesLabel.SetText(fmt.Sprintf("Listino prezzi aggiornato il %s (%d modelli).", t.Updated.Format("02/01/2006"), len(t.Models)))
+		}
+	}
+	showPricesDate(loadPrices())
+	pricesBtn := widget.NewButtonWithIcon("Aggiorna listino prezzi", theme.ViewRefreshIcon(), nil)
+	pricesBtn.OnTapped = func() {
+		hs, err := readNetwork()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		if kind != providerOpenRouter {
+			dialog.ShowInformation("Listino Non Disponibile", fmt.Sprintf("%s non pubblica i prezzi dei modelli: restano i valori indicativi.", providerLabel(kind)), w)
+			return
+		}
+		cfg := providerConfig{Kind: kind, BaseURL: endpointEntry.Text, APIKey: strings.TrimSpace(entry.Text), HTTP: hs}
+		pricesBtn.Disable()
+		go func() {
+			_, err := refreshCatalog(cfg)
+			fyne.Do(func() {
+				pricesBtn.Enable()
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("impossibile aggiornare il listino: %w", err), w)
+					return
+				}
+				showPricesDate(loadPrices())
+			})
+		}()
+	}
+
+	budgetForm := container.NewVBox(
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Budget giornaliero ($):"), budgetDayEntry,
+			widget.NewLabel("Budget mensile ($):"), budgetMonthEntry,
+		),
+		widget.NewLabel("Una generazione che porterebbe la spesa oltre il limite viene bloccata prima dell'invio."),
+		pricesLabel,
+		pricesBtn,
+		widget.NewLabel(fmt.Sprintf("Le variabili d'ambiente %s e %s hanno la precedenza su questi valori.", envBudgetDay, envBudgetMonth)),
+	)
+
+	networkAccordion := widget.NewAccordion(
+		widget.NewAccordionItem("Impostazioni di rete avanzate", networkForm),
+		widget.NewAccordionItem("Limiti di spesa", budgetForm),
+	)
+
+	pickModelBtn.OnTapped = func() {
+		hs, err := readNetwork()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		cfg := providerConfig{Kind: kind, BaseURL: endpointEntry.Text, APIKey: strings.TrimSpace(entry.Text), HTTP: hs}
+		showModelPicker(w, cfg, func(id string) {
+			modelEntry.SetText(withPrimaryModel(modelEntry.Text, id))
+		}, nil)
+	}
+
+	// Probe the local server and list the models it can run
+	probeBtn := widget.NewButtonWithIcon("Verifica server locale", theme.SearchIcon(), nil)
+	probeBtn.OnTapped = func() {
+		hs, err := readNetwork()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		cfg := providerConfig{Kind: kind, BaseURL: endpointEntry.Text, APIKey: strings.TrimSpace(entry.Text), HTTP: hs}
+		probeBtn.Disable()
+		go func() {
+			models, err := listModels(cfg)
+			fyne.Do(func() {
+				probeBtn.Enable()
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("server non raggiungibile su %s: %w", cfg.baseURL(), err), w)
+					return
+				}
+				if len(models) == 0 {
+					dialog.ShowInformation("Nessun Modello", "Il server risponde ma non ha modelli installati.", w)
+					return
+				}
+				current := strings.TrimSpace(modelEntry.Text)
+				found := false
+				for _, m := range models {
+					if m == current {
+						found = true
+					}
+				}
+				if !found {
+					modelEntry.SetText(models[0])
+				}
+				dialog.ShowInformation("Server Raggiungibile", "Modelli disponibili:\n"+strings.Join(models, "\n"), w)
+			})
+		}()
+	}
 
 	// Helper guide button
 	helpBtn := widget.NewButtonWithIcon("Come ottenere la chiave API?", theme.HelpIcon(), func() {
@@ -159,27 +495,64 @@ Il modello predefinito è GPT-4o, ma puoi cambiarlo.`
 
 	save := widget.NewButtonWithIcon("Salva e Continua", theme.ConfirmIcon(), func() {
 		key := strings.TrimSpace(entry.Text)
-		if key == "" {
-			dialog.ShowInformation("Chiave Mancante", "Inserisci una chiave API di OpenRouter valida.", w)
+		cfg := providerConfig{Kind: kind, BaseURL: endpointEntry.Text, APIKey: key}
+		if localCheck.Checked {
+			if cfg.needsKey() {
+				dialog.ShowInformation("Endpoint Non Locale", "In modalità senza chiave l'endpoint deve essere su questo computer o sulla rete locale.", w)
+				return
+			}
+			key = ""
+		} else if key == "" && cfg.needsKey() {
+			dialog.ShowInformation("Chiave Mancante", fmt.Sprintf("Inserisci una chiave API di %s valida.", providerLabel(kind)), w)
 			return
 		}
-		prefs.SetString(prefAPIKey, key)
-
-		model := strings.TrimSpace(modelEntry.Text)
-		if model == "" {
-			model = defaultModel
+		if _, err := readNetwork(); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		for _, e := range []*widget.Entry{budgetDayEntry, budgetMonthEntry} {
+			if _, err := parseBudget(e.Text); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+		}
+		keys[kind] = key
+		for k, v := range keys {
+			prefs.SetString(apiKeyPref(k), strings.TrimSpace(v))
+		}
+		prefs.SetString(prefLegacyAPIKey, "") // now saved per provider
+		prefs.SetString(prefProvider, kind)
+		prefs.SetString(prefBaseURL, strings.TrimSpace(endpointEntry.Text))
+		prefs.SetString(prefProxy, strings.TrimSpace(proxyEntry.Text))
+		prefs.SetString(prefHeaders, strings.TrimSpace(headersEntry.Text))
+		prefs.SetString(prefTimeout, strings.TrimSpace(timeoutEntry.Text))
+		prefs.SetString(prefAttempts, strings.TrimSpace(attemptsEntry.Text))
+		prefs.SetString(prefCABundle, strings.TrimSpace(caEntry.Text))
+		prefs.SetString(prefBudgetDay, strings.TrimSpace(budgetDayEntry.Text))
+		prefs.SetString(prefBudgetMonth, strings.TrimSpace(budgetMonthEntry.Text))
+
+		models := parseModelList(modelEntry.Text)
+		if len(models) == 0 {
+			models = []string{defaultModelFor(kind)}
 		}
-		prefs.SetString(prefModel, model)
+		prefs.SetString(prefModel, strings.Join(models, ", "))
 
 		onNext()
 	})
 
 	return container.NewVBox(
 		widget.NewLabelWithStyle("Configurazione API", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
+		container.NewGridWithColumns(2, widget.NewLabel("Provider:"), providerSelect),
 		info,
+		localCheck,
 		entry,
 		modelLabel,
-		modelEntry,
+		container.NewBorder(nil, nil, nil, pickModelBtn, modelEntry),
+		fallbackHint,
+		endpointLabel,
+		endpointEntry,
+		networkAccordion,
+		probeBtn,
 		helpBtn,
 		save,
 	)
@@ -187,15 +560,134 @@ Il modello predefinito è GPT-4o, ma puoi cambiarlo.`
 
 func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasObject {
 	prefs := a.Preferences()
+	kind := prefs.StringWithFallback(prefProvider, providerOpenRouter)
 	model := strings.TrimSpace(prefs.String(prefModel))
 	if model == "" {
-		model = defaultModel
+		model = defaultModelFor(kind)
 	}
 
 	// State
 	var selectedNames []string
-	var selectedTexts []string
-	var selectedImages []string // data URLs for images
+	var selected material
+
+	// Controls
+	nEntry := widget.NewEntry()
+	nEntry.SetPlaceHolder(fmt.Sprintf("%d", defaultN))
+	nEntry.SetText(fmt.Sprintf("%d", defaultN))
+
+	// Per-style counts of a mixed set, shown when the "Misto" style is
+	// chosen; their total replaces the number of questions
+	mixStyles := append([]string{""}, questionStyles...)
+	mixEntries := make([]*widget.Entry, len(mixStyles))
+	mixGrid := container.NewGridWithColumns(2)
+	for i, style := range mixStyles {
+		mixEntries[i] = widget.NewEntry()
+		mixEntries[i].SetPlaceHolder("0")
+		mixGrid.Add(widget.NewLabel(styleLabel(style) + ":"))
+		mixGrid.Add(mixEntries[i])
+	}
+	mixBox := container.NewVBox(widget.NewLabel("Domande per stile:"), mixGrid)
+	mixBox.Hide()
+	mixActive := false
+	singleStyle := "" // the chosen style when it isn't the mixed one
+	readMix := func() ([]styleShare, error) {
+		var mix []styleShare
+		for i, e := range mixEntries {
+			text := strings.TrimSpace(e.Text)
+			if text == "" {
+				continue
+			}
+			n, err := strconv.Atoi(text)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("numero non valido per %s: %q", styleLabel(mixStyles[i]), text)
+			}
+			if n > 0 {
+				mix = append(mix, styleShare{Style: mixStyles[i], N: n})
+			}
+		}
+		return mix, nil
+	}
+
+	modelEntry := widget.NewEntry()
+	modelEntry.SetPlaceHolder(defaultModelFor(kind))
+	modelEntry.SetText(model)
+
+	// Pre-flight estimate of the next run, refreshed when the files, the
+	// model or the number of questions change
+	cfg, _ := providerConfigFromPrefs(prefs)
+	prices := loadPrices()
+	catalog, _ := loadCatalog(cfg)
+	catalogs := knownCatalogs(cfg, false)
+	estimateLabel := widget.NewLabel("")
+	estimateLabel.Wrapping = fyne.TextWrapWord
+	updateEstimate := func() {
+		if selected.empty() {
+			estimateLabel.SetText("")
+			return
+		}
+		models := parseModelList(modelEntry.Text)
+		if len(models) == 0 {
+			models = []string{defaultModelFor(kind)}
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(nEntry.Text))
+		if err != nil || n < 1 {
+			n = defaultN
+		}
+		var mix []styleShare
+		if mixActive {
+			if mix, _ = readMix(); len(mix) == 0 {
+				estimateLabel.SetText("")
+				return
+			}
+			n = mixTotal(mix)
+		}
+		u, priced := estimateUsage(generationOptions{Model: models[0], N: n, Style: singleStyle, Mix: mix, ContextLength: contextLength(models, catalogs...)}, selected, prices)
+		tokens := u.PromptTokens + u.CompletionTokens
+		switch {
+		case !cfg.needsKey():
+			estimateLabel.SetText(fmt.Sprintf("Stima: ~%d token, nessun costo (modello locale).", tokens))
+		case priced:
+			estimateLabel.SetText(fmt.Sprintf("Costo stimato: ~%s (~%d token).", formatCost(u.Cost), tokens))
+		default:
+			estimateLabel.SetText(fmt.Sprintf("Stima: ~%d token; il prezzo di %s non è nel listino.", tokens, models[0]))
+		}
+		if noVision := catalog.withoutVision(models); len(selected.Images) > 0 && len(noVision) > 0 {
+			estimateLabel.SetText(estimateLabel.Text + fmt.Sprintf("\n⚠ %s non accetta immagini: scegli un modello con supporto immagini.", strings.Join(noVision, ", ")))
+		}
+	}
+	modelEntry.OnChanged = func(string) { updateEstimate() }
+	nEntry.OnChanged = func(string) { updateEstimate() }
+	for _, e := range mixEntries {
+		e.OnChanged = func(string) {
+			if mix, err := readMix(); err == nil {
+				nEntry.SetText(fmt.Sprintf("%d", mixTotal(mix)))
+			}
+			updateEstimate()
+		}
+	}
+
+	// A new catalog also brings new prices when it comes from OpenRouter
+	useCatalog := func(c modelCatalog) {
+		catalog = c
+		catalogs = knownCatalogs(cfg, false)
+		prices = loadPrices()
+		updateEstimate()
+	}
+	if cfg.Kind != providerAnthropic && (len(catalog.Models) == 0 || catalog.stale()) {
+		go func() {
+			if c, err := refreshCatalog(cfg); err == nil {
+				fyne.Do(func() { useCatalog(c) })
+			}
+		}()
+	}
+	pickModelBtn := widget.NewButtonWithIcon("", theme.SearchIcon(), func() {
+		showModelPicker(w, cfg, func(id string) {
+			modelEntry.SetText(withPrimaryModel(modelEntry.Text, id))
+		}, useCatalog)
+	})
+	if cfg.Kind == providerAnthropic {
+		pickModelBtn.Hide()
+	}
 
 	namesLabel := widget.NewLabel("Nessun file selezionato.")
 	updateNames := func() {
@@ -204,17 +696,9 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 		} else {
 			namesLabel.SetText(strings.Join(selectedNames, "\n"))
 		}
+		updateEstimate()
 	}
 
-	// Controls
-	nEntry := widget.NewEntry()
-	nEntry.SetPlaceHolder(fmt.Sprintf("%d", defaultN))
-	nEntry.SetText(fmt.Sprintf("%d", defaultN))
-
-	modelEntry := widget.NewEntry()
-	modelEntry.SetPlaceHolder(defaultModel)
-	modelEntry.SetText(model)
-
 	addFileBtn := widget.NewButtonWithIcon("Aggiungi PDF/PNG/JPG", theme.FileIcon(), func() {
 		fd := dialog.NewFileOpen(func(r fyne.URIReadCloser, err error) {
 			if err != nil {
@@ -239,18 +723,33 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 
 			switch ext {
 			case ".pdf":
-				text, perr := extractTextFromPDFBytes(data)
+				info, perr := extractPDF(data)
 				if perr != nil {
 					dialog.ShowError(fmt.Errorf("PDF parse error: %w", perr), w)
 					return
 				}
-				if strings.TrimSpace(text) == "" {
+				if len(info.Pages) == 0 {
 					dialog.ShowInformation("PDF Vuoto", "Nessun testo estraibile trovato in questo PDF.", w)
 					return
 				}
-				selectedTexts = append(selectedTexts, text)
-				selectedNames = append(selectedNames, fmt.Sprintf("PDF: %s (%.1f KB)", name, float64(len(data))/1024))
-				updateNames()
+				addPDF := func(pages []pageText) {
+					selected.Docs = append(selected.Docs, sourceDoc{Name: name, Pages: pages})
+					label := fmt.Sprintf("PDF: %s (%d pagine, %.1f KB)", name, len(pages), float64(len(data))/1024)
+					if len(pages) < len(info.Pages) {
+						nums := make([]int, len(pages))
+						for i, p := range pages {
+							nums[i] = p.Number
+						}
+						label = fmt.Sprintf("PDF: %s (pagine %s)", name, formatPageRanges(nums))
+					}
+					selectedNames = append(selectedNames, label)
+					updateNames()
+				}
+				if info.PageCount <= 1 {
+					addPDF(info.Pages)
+					return
+				}
+				showPageSelection(w, name, info, addPDF)
 
 			case ".png", ".jpg", ".jpeg":
 				dataURL, ierr := imageBytesToDataURL(data, ext)
@@ -258,7 +757,7 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 					dialog.ShowError(ierr, w)
 					return
 				}
-				selectedImages = append(selectedImages, dataURL)
+				selected.Images = append(selected.Images, sourceImage{Name: name, DataURL: dataURL})
 				selectedNames = append(selectedNames, fmt.Sprintf("Image: %s (%.1f KB)", name, float64(len(data))/1024))
 				updateNames()
 
@@ -275,17 +774,20 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 	questionsOutput.Wrapping = fyne.TextWrapWord
 	questionsOutput.Disable()
 
+	// Live token counter while a generation streams in
+	tokenLabel := widget.NewLabel("")
+
 	answersOutput := widget.NewMultiLineEntry()
 	answersOutput.SetPlaceHolder("Le risposte appariranno qui dopo aver cliccato 'Mostra Risposte'...")
 	answersOutput.Wrapping = fyne.TextWrapWord
 	answersOutput.Disable()
 
-	var currentAnswers string
+	var currentSet *QuestionSet
 	var answersVisible bool
 
 	showAnswersBtn := widget.NewButtonWithIcon("Mostra Risposte", theme.VisibilityIcon(), nil)
 	showAnswersBtn.OnTapped = func() {
-		if currentAnswers == "" {
+		if currentSet == nil {
 			dialog.ShowInformation("Nessuna Risposta", "Genera prima le domande per vedere le risposte.", w)
 			return
 		}
@@ -298,7 +800,7 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 			answersVisible = false
 		} else {
 			// Show answers
-			answersOutput.SetText(currentAnswers)
+			answersOutput.SetText(currentSet.AnswersText())
 			answersOutput.Enable()
 			showAnswersBtn.SetText("Nascondi Risposte")
 			showAnswersBtn.SetIcon(theme.VisibilityOffIcon())
@@ -309,46 +811,89 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 	}
 	showAnswersBtn.Disable()
 
+	quizBtn := widget.NewButtonWithIcon("Quiz", theme.QuestionIcon(), func() {
+		showQuiz(w, currentSet)
+	})
+	quizBtn.Disable()
+
+	// The running generation, if any. genRun counts generations so that one
+	// aborted by Clear doesn't write its outcome over the cleared screen.
+	var cancelGen context.CancelFunc
+	genRun := 0
+
+	stopBtn := widget.NewButtonWithIcon("Interrompi", theme.MediaStopIcon(), func() {
+		if cancelGen != nil {
+			cancelGen()
+		}
+	})
+	stopBtn.Disable()
+
 	clearBtn := widget.NewButtonWithIcon("Cancella", theme.ContentClearIcon(), nil)
 	clearBtn.Importance = widget.DangerImportance
 	clearBtn.OnTapped = func() {
+		if cancelGen != nil {
+			cancelGen()
+		}
+		genRun++
+		tokenLabel.SetText("")
 		selectedNames = nil
-		selectedTexts = nil
-		selectedImages = nil
+		selected = material{}
 		updateNames()
 		questionsOutput.SetText("")
 		answersOutput.SetText("")
-		currentAnswers = ""
+		currentSet = nil
 		answersVisible = false
 		showAnswersBtn.SetText("Mostra Risposte")
 		showAnswersBtn.SetIcon(theme.VisibilityIcon())
 		showAnswersBtn.Disable()
+		quizBtn.Disable()
 	}
 
 	// Question styles section (declared early for genBtn to use)
 	styleCheckbox := widget.NewCheck("Stili risposte", nil)
 
-	styleRadio := widget.NewRadioGroup([]string{
-		"Vero o Falso",
-		"Sequenziale",
-		"Complicate",
-		"Date e numeri",
-	}, nil)
+	styleRadio := widget.NewRadioGroup(append(questionStyles, styleMixedLabel), nil)
 	styleRadio.Disable()
 
+	// The mixed set takes over the number of questions while it's chosen
+	updateMix := func() {
+		active := styleCheckbox.Checked && styleRadio.Selected == styleMixedLabel
+		singleStyle = ""
+		if styleCheckbox.Checked && !active {
+			singleStyle = styleRadio.Selected
+		}
+		if active != mixActive {
+			mixActive = active
+			if active {
+				mixBox.Show()
+				nEntry.Disable()
+				if mix, err := readMix(); err == nil && len(mix) > 0 {
+					nEntry.SetText(fmt.Sprintf("%d", mixTotal(mix)))
+				}
+			} else {
+				mixBox.Hide()
+				nEntry.Enable()
+			}
+		}
+		// Replies to some styles are longer, which changes the estimate
+		updateEstimate()
+	}
+	styleRadio.OnChanged = func(string) { updateMix() }
+
 	styleCheckbox.OnChanged = func(checked bool) {
 		if checked {
 			styleRadio.Enable()
 			if styleRadio.Selected == "" {
-				styleRadio.SetSelected("Vero o Falso")
+				styleRadio.SetSelected(styleMultipleChoice)
 			}
 		} else {
 			styleRadio.Disable()
 		}
+		updateMix()
 	}
 
 	saveBtn := widget.NewButtonWithIcon("Salva Domande", theme.DocumentSaveIcon(), func() {
-		if strings.TrimSpace(questionsOutput.Text) == "" {
+		if currentSet == nil {
 			dialog.ShowInformation("Niente da Salvare", "Esegui prima la generazione per produrre domande.", w)
 			return
 		}
@@ -361,80 +906,244 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 				return
 			}
 			defer wc.Close()
-			content := questionsOutput.Text
-			if currentAnswers != "" {
-				content += "\n\n=== RISPOSTE ===\n\n" + currentAnswers
+			// .json keeps the full structured set so it can be reopened later
+			var werr error
+			if strings.EqualFold(wc.URI().Extension(), ".json") {
+				werr = currentSet.WriteJSON(wc)
+			} else {
+				_, werr = wc.Write([]byte(currentSet.Text()))
 			}
-			if _, err := wc.Write([]byte(content)); err != nil {
-				dialog.ShowError(err, w)
+			if werr != nil {
+				dialog.ShowError(werr, w)
 				return
 			}
 			dialog.ShowInformation("Salvato", "Output salvato con successo.", w)
 		}, w)
 		fs.SetFileName("domande_risposte.txt")
+		fs.SetFilter(storage.NewExtensionFileFilter([]string{".txt", ".json"}))
 		fs.Show()
 	})
 
+	// showSet displays a question set and makes its answers available
+	showSet := func(qs *QuestionSet, footer string) {
+		currentSet = qs
+		text := qs.QuestionsText()
+		if footer != "" {
+			text += "\n\n--\n" + footer
+		}
+		questionsOutput.SetText(text)
+		questionsOutput.Enable() // allow copy
+		answersOutput.SetText("")
+		answersOutput.Disable()
+		answersVisible = false
+		showAnswersBtn.SetText("Mostra Risposte")
+		showAnswersBtn.SetIcon(theme.VisibilityIcon())
+		showAnswersBtn.Enable()
+		quizBtn.Enable()
+	}
+
+	openBtn := widget.NewButtonWithIcon("Apri Domande", theme.FolderOpenIcon(), func() {
+		fd := dialog.NewFileOpen(func(r fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if r == nil {
+				return
+			}
+			defer r.Close()
+			qs, lerr := readQuestionSet(r)
+			if lerr != nil {
+				dialog.ShowError(lerr, w)
+				return
+			}
+			showSet(qs, "Aperto da "+r.URI().Name())
+		}, w)
+		fd.SetFilter(storage.NewExtensionFileFilter([]string{".json"}))
+		fd.Show()
+	})
+
+	usageBtn := widget.NewButtonWithIcon("Consumi", theme.InfoIcon(), func() {
+		showUsageDialog(w)
+	})
+
 	genBtn := widget.NewButtonWithIcon("Genera Domande", theme.MediaPlayIcon(), nil)
 	genBtn.OnTapped = func() {
 		// Validation
-		key := strings.TrimSpace(prefs.String(prefAPIKey))
-		if key == "" {
-			dialog.ShowInformation("Chiave API Mancante", "Imposta la tua chiave API di OpenRouter nel passaggio precedente.", w)
+		cfg, cErr := providerConfigFromPrefs(prefs)
+		if cErr == nil && cfg.needsKey() && cfg.APIKey == "" {
+			dialog.ShowInformation("Chiave API Mancante", fmt.Sprintf("Imposta la tua chiave API di %s nel passaggio precedente.", providerLabel(kind)), w)
 			return
 		}
-		modelStr := strings.TrimSpace(modelEntry.Text)
-		if modelStr == "" {
-			modelStr = defaultModel
+		var provider Provider
+		if cErr == nil {
+			provider, cErr = newProvider(cfg)
+		}
+		if cErr != nil {
+			dialog.ShowError(fmt.Errorf("configurazione di rete non valida: %w", cErr), w)
+			return
+		}
+		models := parseModelList(modelEntry.Text)
+		if len(models) == 0 {
+			models = []string{defaultModelFor(kind)}
 		}
 		nStr := strings.TrimSpace(nEntry.Text)
 		if nStr == "" {
 			nStr = fmt.Sprintf("%d", defaultN)
 		}
 		nVal, err := strconv.Atoi(nStr)
+		var mix []styleShare
+		if mixActive {
+			var mErr error
+			if mix, mErr = readMix(); mErr == nil && (mixTotal(mix) < 1 || mixTotal(mix) > 100) {
+				mErr = fmt.Errorf("il totale delle domande per stile deve essere tra 1 e 100, non %d", mixTotal(mix))
+			}
+			if mErr != nil {
+				dialog.ShowInformation("Distribuzione Non Valida", mErr.Error(), w)
+				return
+			}
+			nVal, err = mixTotal(mix), nil
+		}
 		if err != nil || nVal < 1 || nVal > 100 {
 			dialog.ShowInformation("Numero Non Valido", "Inserisci un numero valido di domande (1-100).", w)
 			return
 		}
-		if len(selectedTexts) == 0 && len(selectedImages) == 0 {
+		if selected.empty() {
 			dialog.ShowInformation("Nessuna Fonte", "Aggiungi almeno un PDF o un'immagine.", w)
 			return
 		}
+		contextLen := contextLength(models, catalogs...)
+
+		// Refuse a run that would go past the spending caps, or that can't
+		// be checked against them because the model's price isn't known
+		est, priced := estimateUsage(generationOptions{Model: models[0], N: nVal, Style: singleStyle, Mix: mix, ContextLength: contextLen}, selected, prices)
+		b, bErr := budgetFromPrefs(prefs)
+		if bErr == nil {
+			bErr = b.checkRun(models[0], est.Cost, priced, cfg.needsKey())
+		}
+		var be *budgetError
+		var unpriced *unpricedError
+		switch {
+		case errors.As(bErr, &be):
+			dialog.ShowInformation("Budget Raggiunto", userMessage(bErr), w)
+			return
+		case errors.As(bErr, &unpriced):
+			dialog.ShowInformation("Prezzo Sconosciuto", userMessage(bErr), w)
+			return
+		case bErr != nil:
+			dialog.ShowError(bErr, w)
+			return
+		}
 
-		// Persist model choice
-		prefs.SetString(prefModel, modelStr)
-
-		// Prepare UI
-		genBtn.Disable()
-		showAnswersBtn.Disable()
-		questionsOutput.SetText("Generazione in corso... Potrebbe richiedere un momento.")
-		questionsOutput.Refresh()
-		answersOutput.SetText("")
-		answersOutput.Refresh()
+		start := func() {
+			// Persist model choice
+			prefs.SetString(prefModel, strings.Join(models, ", "))
+
+			// Prepare UI
+			ctx, cancel := context.WithCancel(context.Background())
+			cancelGen = cancel
+			genRun++
+			run := genRun
+			genBtn.Disable()
+			stopBtn.Enable()
+			showAnswersBtn.Disable()
+			quizBtn.Disable()
+			questionsOutput.SetText("Generazione in corso... Potrebbe richiedere un momento.")
+			questionsOutput.Refresh()
+			answersOutput.SetText("")
+			answersOutput.Refresh()
+			tokenLabel.SetText("")
+
+			questionStyle := singleStyle
+			go func() {
+				start := time.Now()
+				// Show questions as they complete; the token counter is refreshed
+				// a few times a second at most
+				shown, tokens := 0, 0
+				var lastUpdate time.Time
+				onProgress := func(pr generationProgress) {
+					tokens = pr.Tokens
+					if len(pr.Questions) == shown && time.Since(lastUpdate) < 250*time.Millisecond {
+						return
+					}
+					shown = len(pr.Questions)
+					lastUpdate = time.Now()
+					partial := &QuestionSet{Questions: append([]Question(nil), pr.Questions...)}
+					partial.Renumber()
+					fyne.Do(func() {
+						if run != genRun {
+							return
+						}
+						tokenLabel.SetText(fmt.Sprintf("~%d token ricevuti", pr.Tokens))
+						if len(partial.Questions) > 0 {
+							questionsOutput.SetText(partial.QuestionsText() + "\n\n...")
+						}
+					})
+				}
+				opts := generationOptions{Model: models[0], Fallbacks: models[1:], N: nVal, Style: questionStyle, Mix: mix, ContextLength: contextLen, OnProgress: onProgress}
+				qs, usage, gErr := generateQuestionSet(ctx, provider, opts, selected)
+				elapsed := time.Since(start)
+				usage = recordRun("app", provider.Name(), opts, qs, usage, gErr)
+
+				// Update UI, after any pending progress update
+				fyne.Do(func() {
+					cancel()
+					cancelGen = nil
+					genBtn.Enable()
+					stopBtn.Disable()
+					if run != genRun {
+						return // cleared meanwhile
+					}
+					// Interrupted and failed runs are billed too, so say what they used
+					consumed := ""
+					if !usage.Zero() {
+						consumed = "\n\n" + usageText(usage)
+					}
+					if errors.Is(gErr, context.Canceled) {
+						questionsOutput.SetText("Generazione interrotta." + consumed)
+						currentSet = nil
+					} else if gErr != nil {
+						questionsOutput.SetText("Errore: " + userMessage(gErr) + consumed)
+						currentSet = nil
+						questionsOutput.Enable() // allow copy
+					} else {
+						footer := fmt.Sprintf("Generato con %s in %s", qs.Model, elapsed.Truncate(time.Millisecond))
+						if qs.Skipped > 0 {
+							footer += fmt.Sprintf("\n⚠ Il materiale è stato diviso in %d parti, più delle domande richieste: %d parti, lasciate a intervalli regolari, sono rimaste senza domande. Aumenta il numero di domande per coprire tutto il materiale.", qs.Parts, qs.Skipped)
+						}
+						if qs.Requested > 0 {
+							footer += fmt.Sprintf("\n⚠ Sono state generate %d domande delle %d richieste: il modello non ne ha prodotte altre diverse da queste. Aggiungi materiale o chiedi meno domande.", len(qs.Questions), qs.Requested)
+						}
+						if !usage.Zero() {
+							footer += "\n" + usageText(usage)
+							tokenLabel.SetText(fmt.Sprintf("%d token", usage.PromptTokens+usage.CompletionTokens))
+							if usage.Cost > 0 {
+								tokenLabel.SetText(tokenLabel.Text + ", " + formatCost(usage.Cost))
+							}
+						} else if tokens > 0 {
+							tokenLabel.SetText(fmt.Sprintf("~%d token", tokens))
+						}
+						showSet(qs, footer)
+					}
+					questionsOutput.Refresh()
+				})
+			}()
+		}
 
-		go func() {
-			start := time.Now()
-			questionStyle := ""
-			if styleCheckbox.Checked {
-				questionStyle = styleRadio.Selected
+		// Images sent to a text-only model fail with an obscure HTTP error
+		launch := start
+		if noVision := catalog.withoutVision(models); len(selected.Images) > 0 && len(noVision) > 0 {
+			launch = func() {
+				dialog.ShowConfirm("Modello Senza Immagini",
+					fmt.Sprintf("Secondo il catalogo, %s non accetta immagini: la richiesta con le %d immagini selezionate probabilmente fallirà.\nScegli un modello con supporto immagini o rimuovi le immagini.\n\nGenerare comunque?", strings.Join(noVision, ", "), len(selected.Images)),
+					func(ok bool) {
+						if ok {
+							start()
+						}
+					}, w)
 			}
-			questions, answers, cost, gErr := generateQuestionsAndAnswers(key, modelStr, nVal, selectedTexts, selectedImages, questionStyle)
-			elapsed := time.Since(start)
-
-			// Update UI
-			genBtn.Enable()
-			if gErr != nil {
-				questionsOutput.SetText(fmt.Sprintf("Errore: %v", gErr))
-				currentAnswers = ""
-			} else {
-				questionsOutput.SetText(fmt.Sprintf("%s\n\n--\nGenerato in %s", strings.TrimSpace(questions), elapsed.Truncate(time.Millisecond)))
-				currentAnswers = answers
-				showAnswersBtn.Enable()
-				_ = cost // ignore cost for now
-			}
-			questionsOutput.Enable() // allow copy
-			questionsOutput.Refresh()
-		}()
+		}
+		launch()
 	}
 
 	// Settings button (only show if callback provided)
@@ -450,7 +1159,7 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 
 	controls := container.NewHBox(addFileBtn, clearBtn)
 	params := container.NewGridWithColumns(2,
-		widget.NewLabel("Modello:"), modelEntry,
+		widget.NewLabel("Modelli:"), container.NewBorder(nil, nil, nil, pickModelBtn, modelEntry),
 		widget.NewLabel("Numero di Domande:"), nEntry,
 	)
 
@@ -464,6 +1173,9 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 
 	helpText2 := widget.NewLabel("Ogni generazione utilizza il credito di OpenRouter. Puoi anche utilizzare modelli meno precisi per un costo più basso, oppure modelli più costosi ma che riescono a gestire un numero maggiore di documenti.")
 	helpText2.Wrapping = fyne.TextWrapWord
+	if !cfg.needsKey() {
+		helpText2.SetText("Il modello gira su un server locale: nessun credito consumato e il materiale non lascia la rete.")
+	}
 
 	pdfHint := widget.NewLabel("Si consiglia di usare PDF")
 	pdfHint.TextStyle = fyne.TextStyle{Italic: true}
@@ -485,12 +1197,14 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 		fileListScroll,
 		controls,
 		params,
+		estimateLabel,
 		widget.NewSeparator(),
 		styleCheckbox,
 		styleRadio,
+		mixBox,
 		widget.NewSeparator(),
-		genBtn,
-		saveBtn,
+		container.NewBorder(nil, nil, nil, stopBtn, genBtn),
+		container.NewGridWithColumns(3, saveBtn, openBtn, usageBtn),
 		widget.NewSeparator(),
 		helpText1,
 		helpText2,
@@ -499,7 +1213,7 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 	// Vertical split for questions and answers with "Mostra Risposte" button in answer section
 	rightPanel := container.NewVSplit(
 		container.NewBorder(
-			widget.NewLabel("Domande:"),
+			container.NewBorder(nil, nil, container.NewHBox(widget.NewLabel("Domande:"), tokenLabel), quizBtn),
 			nil, nil, nil,
 			container.NewMax(container.NewVScroll(questionsOutput)),
 		),
@@ -520,27 +1234,6 @@ func createMainScreen(a fyne.App, w fyne.Window, onSettings func()) fyne.CanvasO
 	return content
 }
 
-func extractTextFromPDFBytes(data []byte) (string, error) {
-	rd := bytes.NewReader(data)
-	pdfReader, err := pdf.NewReader(rd, int64(len(data)))
-	if err != nil {
-		return "", err
-	}
-	var buf bytes.Buffer
-	pt, err := pdfReader.GetPlainText()
-	if err != nil {
-		return "", err
-	}
-	_, _ = io.Copy(&buf, pt)
-	text := buf.String()
-	// trim and clamp size
-	text = strings.TrimSpace(text)
-	if len(text) > maxTextChars {
-		text = text[:maxTextChars] + "\n...[truncated]..."
-	}
-	return text, nil
-}
-
 func imageBytesToDataURL(data []byte, ext string) (string, error) {
 	// Normalize ext to mime
 	ext = strings.ToLower(ext)
@@ -559,48 +1252,7 @@ func imageBytesToDataURL(data []byte, ext string) (string, error) {
 	return fmt.Sprintf("data:%s;base64,%s", mtyp, enc), nil
 }
 
-// OpenRouter types
-
-type contentPart struct {
-	Type     string    `json:"type"`                // "text" or "image_url"
-	Text     string    `json:"text,omitempty"`      // for text
-	ImageURL *imageURL `json:"image_url,omitempty"` // for image
-}
-
-type imageURL struct {
-	URL string `json:"url"`
-}
-
-type message struct {
-	Role    string      `json:"role"`
-	Content interface{} `json:"content"` // string or []contentPart
-}
-
-type chatRequest struct {
-	Model       string    `json:"model"`
-	Messages    []message `json:"messages"`
-	Temperature float64   `json:"temperature,omitempty"`
-}
-
-type chatResponse struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Choices []struct {
-		Index   int `json:"index"`
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
-		FinishReason string `json:"finish_reason"`
-	} `json:"choices"`
-	Error *struct {
-		Message string `json:"message"`
-		Type    string `json:"type"`
-	} `json:"error,omitempty"`
-}
-
-func generateQuestions(apiKey, model string, n int, texts []string, imageDataURLs []string) (string, error) {
+func generateQuestions(p Provider, model string, n int, texts []string, imageDataURLs []string) (string, error) {
 	systemPrompt := "Sei un insegnante esperto. Dal materiale di studio fornito, estrai prima i punti principali, poi formula domande numerate che riflettono quei punti. Usa solo il contenuto fornito; non aggiungere contesto esterno. Rispondi SEMPRE in italiano."
 
 	// Build text body
@@ -608,8 +1260,8 @@ func generateQuestions(apiKey, model string, n int, texts []string, imageDataURL
 	if len(texts) > 0 {
 		// Join with delimiter
 		mergedText = strings.Join(texts, "\n\n---\n\n")
-		if len(mergedText) > maxTextChars {
-			mergedText = mergedText[:maxTextChars] + "\n...[troncato]..."
+		if budget := inputBudget(0, n, "", len(imageDataURLs)); len(mergedText) > budget {
+			mergedText = cutUTF8(mergedText, budget) + "\n...[troncato]..."
 		}
 	}
 
@@ -637,187 +1289,23 @@ func generateQuestions(apiKey, model string, n int, texts []string, imageDataURL
 		})
 	}
 
-	reqBody := chatRequest{
-		Model: model,
-		Messages: []message{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: parts},
-		},
+	resp, err := p.Complete(context.Background(), completionRequest{
+		Model:       model,
+		System:      systemPrompt,
+		Parts:       parts,
 		Temperature: 0.2,
-	}
-
-	payload, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", err
-	}
-
-	httpReq, err := http.NewRequest(http.MethodPost, openRouterURL, bytes.NewReader(payload))
-	if err != nil {
-		return "", err
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	httpReq.Header.Set("HTTP-Referer", refererHeader)
-	httpReq.Header.Set("X-Title", xTitleHeader)
-
-	client := &http.Client{Timeout: 90 * time.Second}
-	resp, err := client.Do(httpReq)
+	})
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("OpenRouter HTTP %d: %s", resp.StatusCode, truncate(string(body), 500))
-	}
-
-	var cr chatResponse
-	if err := json.Unmarshal(body, &cr); err != nil {
-		// Try to surface raw body if unmarshalling fails
-		return "", fmt.Errorf("failed to decode response: %v\nRaw: %s", err, truncate(string(body), 800))
-	}
-	if cr.Error != nil {
-		return "", fmt.Errorf("OpenRouter error: %s (%s)", cr.Error.Message, cr.Error.Type)
-	}
-	if len(cr.Choices) == 0 {
-		return "", fmt.Errorf("no choices returned")
-	}
-	return cr.Choices[0].Message.Content, nil
-}
-
-func generateQuestionsAndAnswers(apiKey, model string, n int, texts []string, imageDataURLs []string, questionStyle string) (string, string, float64, error) {
-	// Build merged text
-	var mergedText string
-	if len(texts) > 0 {
-		mergedText = strings.Join(texts, "\n\n---\n\n")
-		if len(mergedText) > maxTextChars {
-			mergedText = mergedText[:maxTextChars] + "\n...[troncato]..."
-		}
-	}
-
-	// Build content parts
-	var parts []contentPart
-	var b strings.Builder
-
-	// Different prompts based on question style
-	switch questionStyle {
-	case "Vero o Falso":
-		fmt.Fprintf(&b, "Istruzioni:\n- Estrai i punti principali dal materiale fornito.\n- Produci esattamente %d domande VERO o FALSO IN ITALIANO.\n- Usa questo formato RIGOROSO:\n\n", n)
-		b.WriteString("DOMANDE:\n1. [Affermazione che può essere vera o falsa]\n2. [Affermazione che può essere vera o falsa]\n...\n\n")
-		b.WriteString("RISPOSTE:\n1. Vero / Falso (con breve spiegazione)\n2. Vero / Falso (con breve spiegazione)\n...\n\n")
-	case "Sequenziale":
-		fmt.Fprintf(&b, "Istruzioni:\n- Estrai eventi, processi o passaggi sequenziali dal materiale fornito.\n- Produci esattamente %d domande SEQUENZIALI IN ITALIANO che richiedono di ordinare o descrivere una sequenza.\n- Usa questo formato RIGOROSO:\n\n", n)
-		b.WriteString("DOMANDE:\n1. Qual è la sequenza corretta di...?\n2. Metti in ordine i seguenti passaggi...\n...\n\n")
-		b.WriteString("RISPOSTE:\n1. La sequenza corretta è: ...\n2. L'ordine corretto è: ...\n...\n\n")
-	case "Complicate":
-		fmt.Fprintf(&b, "Istruzioni:\n- Estrai concetti complessi e relazioni dal materiale fornito.\n- Produci esattamente %d domande COMPLESSE IN ITALIANO che richiedono analisi approfondita, confronto, o sintesi di più concetti.\n- Usa questo formato RIGOROSO:\n\n", n)
-		b.WriteString("DOMANDE:\n1. Spiega la relazione tra... e come...\n2. Confronta e analizza...\n3. Perché... e quali sono le implicazioni di...\n...\n\n")
-		b.WriteString("RISPOSTE:\n1. [Risposta articolata e dettagliata]\n2. [Risposta articolata e dettagliata]\n...\n\n")
-	case "Date e numeri":
-		fmt.Fprintf(&b, "Istruzioni:\n- Estrai date, numeri, statistiche e dati numerici specifici dal materiale fornito.\n- Produci esattamente %d domande IN ITALIANO incentrate su DATE e NUMERI.\n- Usa questo formato RIGOROSO:\n\n", n)
-		b.WriteString("DOMANDE:\n1. In che anno...?\n2. Quanti...?\n3. Qual è la percentuale di...?\n...\n\n")
-		b.WriteString("RISPOSTE:\n1. [Anno o data specifica]\n2. [Numero specifico]\n3. [Percentuale o valore numerico]\n...\n\n")
-	default:
-		// Standard format
-		fmt.Fprintf(&b, "Istruzioni:\n- Estrai i punti principali dal materiale fornito.\n- Produci esattamente %d domande IN ITALIANO con le relative risposte.\n- Usa questo formato RIGOROSO:\n\n", n)
-		b.WriteString("DOMANDE:\n1. Prima domanda\n2. Seconda domanda\n3. Terza domanda\n...\n\n")
-		b.WriteString("RISPOSTE:\n1. Risposta alla prima domanda\n2. Risposta alla seconda domanda\n3. Risposta alla terza domanda\n...\n\n")
-	}
-
-	b.WriteString("- Non aggiungere testo introduttivo o conclusivo.\n- Scrivi TUTTO in italiano.\n- Usa SOLO informazioni dal materiale fornito.\n\n")
-
-	if strings.TrimSpace(mergedText) != "" {
-		b.WriteString("Materiale di studio:\n")
-		b.WriteString(mergedText)
-	}
-
-	parts = append(parts, contentPart{Type: "text", Text: b.String()})
-
-	for _, du := range imageDataURLs {
-		parts = append(parts, contentPart{
-			Type:     "image_url",
-			ImageURL: &imageURL{URL: du},
-		})
-	}
-
-	reqBody := chatRequest{
-		Model: model,
-		Messages: []message{
-			{Role: "system", Content: "Sei un insegnante esperto. Genera domande e risposte in italiano dal materiale fornito."},
-			{Role: "user", Content: parts},
-		},
-		Temperature: 0.2,
-	}
-
-	payload, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", "", 0, err
-	}
-
-	httpReq, err := http.NewRequest(http.MethodPost, openRouterURL, bytes.NewReader(payload))
-	if err != nil {
-		return "", "", 0, err
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	httpReq.Header.Set("HTTP-Referer", refererHeader)
-	httpReq.Header.Set("X-Title", xTitleHeader)
-
-	client := &http.Client{Timeout: 90 * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return "", "", 0, err
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", "", 0, fmt.Errorf("OpenRouter HTTP %d: %s", resp.StatusCode, truncate(string(body), 500))
-	}
-
-	var cr chatResponse
-	if err := json.Unmarshal(body, &cr); err != nil {
-		return "", "", 0, fmt.Errorf("failed to decode response: %v\nRaw: %s", err, truncate(string(body), 800))
-	}
-	if cr.Error != nil {
-		return "", "", 0, fmt.Errorf("OpenRouter error: %s (%s)", cr.Error.Message, cr.Error.Type)
-	}
-	if len(cr.Choices) == 0 {
-		return "", "", 0, fmt.Errorf("no choices returned")
-	}
-
-	fullResponse := cr.Choices[0].Message.Content
-
-	// Parse questions and answers
-	questions := ""
-	answers := ""
-
-	parts2 := strings.Split(fullResponse, "RISPOSTE:")
-	if len(parts2) >= 2 {
-		questionPart := strings.TrimSpace(parts2[0])
-		answerPart := strings.TrimSpace(parts2[1])
-
-		questionPart = strings.TrimPrefix(questionPart, "DOMANDE:")
-		questions = strings.TrimSpace(questionPart)
-		answers = answerPart
-	} else {
-		questions = fullResponse
-		answers = "Risposte non disponibili"
-	}
-
-	// Estimate cost (approximate based on typical pricing)
-	// OpenRouter charges vary, but $0.01 per 1K tokens is a rough estimate for GPT-4o
-	estimatedCost := 0.0 // We can't get exact cost from response, so leaving at 0
-
-	return questions, answers, estimatedCost, nil
+	return resp.Content, nil
 }
 
 func truncate(s string, max int) string {
 	if len(s) <= max {
 		return s
 	}
-	return s[:max] + "...[truncated]"
+	return cutUTF8(s, max) + "...[truncated]"
 }
 
 // Optional: allow running headless to test API without UI
@@ -828,7 +1316,17 @@ func init() {
 			fmt.Println("Set OPENROUTER_API_KEY to run --selftest")
 			os.Exit(1)
 		}
-		resp, err := generateQuestions(key, defaultModel, 5, []string{"Photosynthesis converts light energy to chemical energy in plants. Chlorophyll, thylakoid membranes, and the Calvin cycle are key components."}, nil)
+		cfg := providerConfig{Kind: providerOpenRouter, APIKey: key}
+		err := cfg.applyEnv()
+		var p Provider
+		if err == nil {
+			p, err = newProvider(cfg)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+		resp, err := generateQuestions(p, defaultModel, 5, []string{"Photosynthesis converts light energy to chemical energy in plants. Chlorophyll, thylakoid membranes, and the Calvin cycle are key components."}, nil)
 		if err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(2)
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// modelDetails summarizes what the picker knows about a model.
+func modelDetails(m modelInfo) string {
+	var parts []string
+	if m.ContextLength > 0 {
+		parts = append(parts, fmt.Sprintf("%s token di contesto", formatTokens(m.ContextLength)))
+	}
+	if supported, known := m.vision(); known {
+		if supported {
+			parts = append(parts, "accetta immagini")
+		} else {
+			parts = append(parts, "solo testo")
+		}
+	}
+	if p := m.Price; p != nil {
+		if p.Prompt == 0 && p.Completion == 0 {
+			parts = append(parts, "gratuito")
+		} else {
+			parts = append(parts, fmt.Sprintf("$%.2f / $%.2f per milione di token (ingresso/uscita)", p.Prompt*1e6, p.Completion*1e6))
+		}
+	}
+	if len(parts) == 0 {
+		return "nessun dettaglio dal provider"
+	}
+	return strings.Join(parts, " · ")
+}
+
+// showModelPicker lets the user search the provider's models and calls
+// onPick with the chosen ID. The catalog is read from the disk cache, so it
+// works offline, and downloaded again when missing, stale or on request;
+// onRefresh receives each newly downloaded catalog.
+func showModelPicker(w fyne.Window, cfg providerConfig, onPick func(id string), onRefresh func(modelCatalog)) {
+	catalog, cached := loadCatalog(cfg)
+	var shown []modelInfo
+
+	searchEntry := widget.NewEntry()
+	searchEntry.SetPlaceHolder("Cerca per nome, es. gpt-4o, claude, gemini flash")
+	visionCheck := widget.NewCheck("Solo modelli che accettano immagini", nil)
+	statusLabel := widget.NewLabel("")
+
+	list := widget.NewList(
+		func() int { return len(shown) },
+		func() fyne.CanvasObject {
+			id := widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+			details := widget.NewLabel("")
+			return container.NewVBox(id, details)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			m := shown[i]
+			box := o.(*fyne.Container)
+			title := m.ID
+			if m.Name != "" && m.Name != m.ID {
+				title += " - " + m.Name
+			}
+			box.Objects[0].(*widget.Label).SetText(title)
+			box.Objects[1].(*widget.Label).SetText(modelDetails(m))
+		},
+	)
+
+	filter := func() {
+		shown = catalog.search(searchEntry.Text, visionCheck.Checked)
+		list.UnselectAll()
+		list.Refresh()
+		if len(catalog.Models) > 0 {
+			statusLabel.SetText(fmt.Sprintf("%d di %d modelli, elenco del %s.", len(shown), len(catalog.Models), catalog.Updated.Format("02/01/2006 15:04")))
+		}
+	}
+	searchEntry.OnChanged = func(string) { filter() }
+	visionCheck.OnChanged = func(bool) { filter() }
+
+	refreshBtn := widget.NewButtonWithIcon("Aggiorna", theme.ViewRefreshIcon(), nil)
+	refresh := func() {
+		refreshBtn.Disable()
+		statusLabel.SetText("Scaricamento dell'elenco dei modelli...")
+		go func() {
+			fresh, err := refreshCatalog(cfg)
+			fyne.Do(func() {
+				refreshBtn.Enable()
+				if err != nil && len(fresh.Models) == 0 {
+					if cached {
+						filter()
+						statusLabel.SetText(statusLabel.Text + " Aggiornamento non riuscito, uso l'elenco salvato.")
+					} else {
+						statusLabel.SetText("Impossibile scaricare l'elenco dei modelli: " + err.Error())
+					}
+					return
+				}
+				catalog, cached = fresh, true
+				filter()
+				if onRefresh != nil {
+					onRefresh(fresh)
+				}
+			})
+		}()
+	}
+	refreshBtn.OnTapped = refresh
+
+	content := container.NewBorder(
+		container.NewVBox(
+			container.NewBorder(nil, nil, nil, refreshBtn, searchEntry),
+			visionCheck,
+		),
+		statusLabel,
+		nil, nil,
+		list,
+	)
+	d := dialog.NewCustom("Scegli Modello", "Chiudi", content, w)
+	list.OnSelected = func(i widget.ListItemID) {
+		id := shown[i].ID
+		d.Hide()
+		onPick(id)
+	}
+	d.Resize(fyne.NewSize(700, 560))
+	d.Show()
+
+	if cached {
+		filter()
+	}
+	if !cached || catalog.stale() {
+		refresh()
+	}
+}
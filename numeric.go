@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of numeric answers
+const (
+	numericYear    = "year"
+	numericDate    = "date"
+	numericNumber  = "number"
+	numericPercent = "percent"
+)
+
+var numericKinds = []string{numericYear, numericDate, numericNumber, numericPercent}
+
+// NumericAnswer is the typed answer of a "Date e numeri" question, so that
+// a typed answer can be checked within a tolerance.
+type NumericAnswer struct {
+	Kind      string  `json:"kind"`
+	Value     float64 `json:"value"`          // the year, number or percentage; unused for dates
+	Date      string  `json:"date,omitempty"` // dates only, as 2006-01-02
+	Unit      string  `json:"unit,omitempty"` // numbers only, e.g. "km" or "milioni di abitanti"
+	Tolerance float64 `json:"tolerance"`      // accepted absolute error, in days for dates
+}
+
+const dateLayout = "2006-01-02"
+
+// String prints the answer the Italian way, with a decimal comma.
+func (n NumericAnswer) String() string {
+	switch n.Kind {
+	case numericDate:
+		if d, err := time.Parse(dateLayout, n.Date); err == nil {
+			return d.Format("02/01/2006")
+		}
+		return n.Date
+	case numericPercent:
+		return formatNumber(n.Value) + "%"
+	case numericNumber:
+		return strings.TrimSpace(formatNumber(n.Value) + " " + n.Unit)
+	}
+	return formatNumber(n.Value)
+}
+
+// ToleranceText describes the accepted error, or "" when the answer must
+// be exact.
+func (n NumericAnswer) ToleranceText() string {
+	switch {
+	case n.Tolerance <= 0:
+		return ""
+	case n.Kind == numericDate && n.Tolerance == 1:
+		return "±1 giorno"
+	case n.Kind == numericDate:
+		return fmt.Sprintf("±%s giorni", formatNumber(n.Tolerance))
+	case n.Kind == numericYear && n.Tolerance == 1:
+		return "±1 anno"
+	case n.Kind == numericYear:
+		return fmt.Sprintf("±%s anni", formatNumber(n.Tolerance))
+	case n.Kind == numericPercent:
+		return fmt.Sprintf("±%s%%", formatNumber(n.Tolerance))
+	}
+	return strings.TrimSpace(fmt.Sprintf("±%s %s", formatNumber(n.Tolerance), n.Unit))
+}
+
+func formatNumber(v float64) string {
+	return strings.Replace(strconv.FormatFloat(v, 'f', -1, 64), ".", ",", 1)
+}
+
+// Check reports whether response is the answer within the tolerance.
+// understood is false when response isn't a number, or a date, at all.
+func (n NumericAnswer) Check(response string) (ok, understood bool) {
+	if n.Kind == numericDate {
+		want, err := time.Parse(dateLayout, n.Date)
+		if err != nil {
+			return false, false
+		}
+		got, err := parseDate(response)
+		if err != nil {
+			return false, false
+		}
+		days := math.Abs(got.Sub(want).Hours() / 24)
+		return days <= n.Tolerance, true
+	}
+	got, err := parseNumber(response)
+	if err != nil {
+		return false, false
+	}
+	// A relative epsilon absorbs floating point noise, as in 0.1+0.2
+	return math.Abs(got-n.Value) <= n.Tolerance+1e-9*math.Max(1, math.Abs(n.Value)), true
+}
+
+// CheckNumber checks a typed answer to a numeric question.
+func (q Question) CheckNumber(response string) (ok, understood bool) {
+	if q.Numeric == nil {
+		return false, false
+	}
+	return q.Numeric.Check(response)
+}
+
+var numberPattern = regexp.MustCompile(`[-−+]?(?:\d[\d.,]*|[.,]\d+)(?:[eE][-+]?\d+)?`)
+
+// parseNumber reads the first number in s, as typed by an Italian or an
+// English speaker: "1.234,5", "1,234.5", "3,5", "3.5", ",5" and "1e3" all
+// work. A lone comma is a decimal comma; a lone dot followed by exactly
+// three digits is a thousands separator, as in "1.789", unless the number
+// starts with 0.
+func parseNumber(s string) (float64, error) {
+	m := numberPattern.FindString(s)
+	if m == "" {
+		return 0, fmt.Errorf("no number in %q", s)
+	}
+	m = strings.Replace(m, "−", "-", 1)
+	exp := ""
+	if i := strings.IndexAny(m, "eE"); i >= 0 {
+		m, exp = m[:i], m[i:]
+	}
+	m = strings.TrimRight(m, ".,")
+	dot, comma := strings.LastIndex(m, "."), strings.LastIndex(m, ",")
+	switch {
+	case dot >= 0 && comma >= 0:
+		// The last separator is the decimal one
+		if comma > dot {
+			m = strings.ReplaceAll(m, ".", "")
+			m = strings.Replace(m, ",", ".", 1)
+		} else {
+			m = strings.ReplaceAll(m, ",", "")
+		}
+	case comma >= 0:
+		if strings.Count(m, ",") > 1 {
+			m = strings.ReplaceAll(m, ",", "")
+		} else {
+			m = strings.Replace(m, ",", ".", 1)
+		}
+	case dot >= 0:
+		whole := strings.TrimLeft(m[:dot], "+-")
+		if strings.Count(m, ".") > 1 || len(m)-dot-1 == 3 && whole != "" && !strings.HasPrefix(whole, "0") {
+			m = strings.ReplaceAll(m, ".", "")
+		}
+	}
+	return strconv.ParseFloat(m+exp, 64)
+}
+
+var italianMonths = map[string]time.Month{
+	"gennaio": time.January, "febbraio": time.February, "marzo": time.March,
+	"aprile": time.April, "maggio": time.May, "giugno": time.June,
+	"luglio": time.July, "agosto": time.August, "settembre": time.September,
+	"ottobre": time.October, "novembre": time.November, "dicembre": time.December,
+}
+
+var (
+	numericDatePattern = regexp.MustCompile(`^(\d{1,2})[/.-](\d{1,2})[/.-](\d{1,4})$`)
+	isoDatePattern     = regexp.MustCompile(`^(\d{1,4})-(\d{1,2})-(\d{1,2})$`)
+	wordDatePattern    = regexp.MustCompile(`^(\d{1,2})°?\s+([a-zà-ù]+)\s+(\d{1,4})$`)
+)
+
+// parseDate reads a date as 14/07/1789, 14-7-1789, 1789-07-14 or
+// 14 luglio 1789.
+func parseDate(s string) (time.Time, error) {
+	s = strings.ToLower(strings.Join(strings.Fields(strings.TrimSpace(s)), " "))
+	var day, month, year int
+	var ok bool
+	if m := isoDatePattern.FindStringSubmatch(s); m != nil {
+		year, month, day, ok = atoi(m[1]), atoi(m[2]), atoi(m[3]), true
+	} else if m := numericDatePattern.FindStringSubmatch(s); m != nil {
+		day, month, year, ok = atoi(m[1]), atoi(m[2]), atoi(m[3]), true
+	} else if m := wordDatePattern.FindStringSubmatch(s); m != nil {
+		var mon time.Month
+		mon, ok = italianMonths[m[2]]
+		day, month, year = atoi(m[1]), int(mon), atoi(m[3])
+	}
+	if !ok || month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, fmt.Errorf("not a date: %q", s)
+	}
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Day() != day {
+		return time.Time{}, fmt.Errorf("not a date: %q", s)
+	}
+	return t, nil
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// numericFromText guesses the typed answer from an answer written as text,
+// for models that leave "numeric" out.
+func numericFromText(answer string) *NumericAnswer {
+	answer = strings.TrimSpace(answer)
+	if d, err := parseDate(answer); err == nil {
+		return &NumericAnswer{Kind: numericDate, Date: d.Format(dateLayout)}
+	}
+	m := numberPattern.FindStringIndex(answer)
+	if m == nil {
+		return nil
+	}
+	v, err := parseNumber(answer)
+	if err != nil {
+		return nil
+	}
+	if m[0] > 0 {
+		// Numbers in the middle of prose are too risky to check
+		return nil
+	}
+	digits, rest := answer[m[0]:m[1]], strings.TrimSpace(answer[m[1]:])
+	switch {
+	case strings.HasPrefix(rest, "%"):
+		return &NumericAnswer{Kind: numericPercent, Value: v}
+	case rest == "" && (len(digits) == 3 || len(digits) == 4) && !strings.ContainsAny(digits, ".,eE"):
+		return &NumericAnswer{Kind: numericYear, Value: v}
+	}
+	return &NumericAnswer{Kind: numericNumber, Value: v, Unit: rest}
+}
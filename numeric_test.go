@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "42", want: 42},
+		{in: "-7", want: -7},
+		{in: "−7", want: -7},
+		{in: "3,5", want: 3.5},
+		{in: "3.5", want: 3.5},
+		{in: "1.234,5", want: 1234.5},
+		{in: "1,234.5", want: 1234.5},
+		{in: "1.789", want: 1789},
+		{in: "1.234.567", want: 1234567},
+		{in: "1,234,567", want: 1234567},
+		{in: "0.125", want: 0.125},
+		{in: ".125", want: 0.125},
+		{in: ".5", want: 0.5},
+		{in: "+.5", want: 0.5},
+		{in: "-,5", want: -0.5},
+		{in: "1e3", want: 1000},
+		{in: "2,5e-2", want: 0.025},
+		{in: "1.5E2", want: 150},
+		{in: "circa 300 km", want: 300},
+		{in: "42%", want: 42},
+		{in: "5.", want: 5},
+		{in: "3 euro", want: 3},
+		{in: "", wantErr: true},
+		{in: "boh", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseNumber(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseNumber(%q) = %v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("parseNumber(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // as 2006-01-02
+		wantErr bool
+	}{
+		{in: "14/07/1789", want: "1789-07-14"},
+		{in: "14-7-1789", want: "1789-07-14"},
+		{in: "14.07.1789", want: "1789-07-14"},
+		{in: "1789-07-14", want: "1789-07-14"},
+		{in: "14 luglio 1789", want: "1789-07-14"},
+		{in: " 1°  Gennaio 1948 ", want: "1948-01-01"},
+		{in: "29/02/2024", want: "2024-02-29"},
+		{in: "29/02/2023", wantErr: true},
+		{in: "31/04/2020", wantErr: true},
+		{in: "14/13/1789", wantErr: true},
+		{in: "14 luglo 1789", wantErr: true},
+		{in: "1789", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseDate(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseDate(%q) = %v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || got.Format(time.DateOnly) != tt.want {
+				t.Errorf("parseDate(%q) = %v, %v, want %s", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumericCheck(t *testing.T) {
+	tests := []struct {
+		name           string
+		answer         NumericAnswer
+		response       string
+		ok, understood bool
+	}{
+		{"exact year", NumericAnswer{Kind: numericYear, Value: 1789}, "1789", true, true},
+		{"year off by one", NumericAnswer{Kind: numericYear, Value: 1789}, "1790", false, true},
+		{"year within tolerance", NumericAnswer{Kind: numericYear, Value: 1789, Tolerance: 1}, "1790", true, true},
+		{"leading decimal point", NumericAnswer{Kind: numericNumber, Value: 0.5}, ".5", true, true},
+		{"exponent", NumericAnswer{Kind: numericNumber, Value: 1000}, "1e3", true, true},
+		{"floating point noise", NumericAnswer{Kind: numericNumber, Value: 0.3}, "0,30000000000000004", true, true},
+		{"date within a day", NumericAnswer{Kind: numericDate, Date: "1789-07-14", Tolerance: 1}, "15 luglio 1789", true, true},
+		{"date two days off", NumericAnswer{Kind: numericDate, Date: "1789-07-14", Tolerance: 1}, "16/07/1789", false, true},
+		{"not a number", NumericAnswer{Kind: numericNumber, Value: 3}, "tre", false, false},
+		{"not a date", NumericAnswer{Kind: numericDate, Date: "1789-07-14"}, "luglio", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, understood := tt.answer.Check(tt.response)
+			if ok != tt.ok || understood != tt.understood {
+				t.Errorf("Check(%q) = %v, %v, want %v, %v", tt.response, ok, understood, tt.ok, tt.understood)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// showPageSelection lets the user choose which pages of a PDF to use, by
+// typing page ranges or ticking outline sections, with a preview of the
+// text extracted from each page. onDone receives the chosen pages.
+func showPageSelection(w fyne.Window, name string, info *pdfInfo, onDone func([]pageText)) {
+	selectedPages := func(spec string) ([]pageText, error) {
+		nums, err := parsePageRanges(spec, info.PageCount)
+		if err != nil {
+			return nil, err
+		}
+		pages := filterPages(info.Pages, nums)
+		if len(pages) == 0 {
+			return nil, errors.New("nessuna pagina con testo nella selezione")
+		}
+		return pages, nil
+	}
+
+	summary := widget.NewLabel("")
+	rangeEntry := widget.NewEntry()
+	rangeEntry.SetPlaceHolder("es. 1-5, 8, 10-12 (vuoto = tutte)")
+	rangeEntry.Validator = func(s string) error {
+		_, err := selectedPages(s)
+		return err
+	}
+	updateSummary := func(spec string) {
+		pages, err := selectedPages(spec)
+		if err != nil {
+			summary.SetText(err.Error())
+			return
+		}
+		chars := 0
+		for _, p := range pages {
+			chars += len(p.Text)
+		}
+		summary.SetText(fmt.Sprintf("%d pagine con testo selezionate su %d (~%d caratteri)", len(pages), info.PageCount, chars))
+	}
+	rangeEntry.OnChanged = updateSummary
+	updateSummary("")
+
+	// Outline sections; ticking them fills in the page ranges
+	var sectionsBox fyne.CanvasObject = widget.NewLabel("Il PDF non ha segnalibri.")
+	if len(info.Sections) > 0 {
+		byLabel := make(map[string]pdfSection, len(info.Sections))
+		var labels []string
+		for _, sec := range info.Sections {
+			label := fmt.Sprintf("%s%s (p. %d-%d)", strings.Repeat("    ", sec.Level), sec.Title, sec.FirstPage, sec.LastPage)
+			if _, dup := byLabel[label]; dup {
+				continue
+			}
+			byLabel[label] = sec
+			labels = append(labels, label)
+		}
+		group := widget.NewCheckGroup(labels, func(checked []string) {
+			var nums []int
+			seen := map[int]bool{}
+			for _, l := range checked {
+				sec := byLabel[l]
+				for p := sec.FirstPage; p <= sec.LastPage; p++ {
+					if !seen[p] {
+						seen[p] = true
+						nums = append(nums, p)
+					}
+				}
+			}
+			sort.Ints(nums)
+			rangeEntry.SetText(formatPageRanges(nums))
+		})
+		scroll := container.NewVScroll(group)
+		scroll.SetMinSize(fyne.NewSize(0, 160))
+		sectionsBox = scroll
+	}
+
+	// Per-page preview
+	preview := widget.NewMultiLineEntry()
+	preview.Wrapping = fyne.TextWrapWord
+	preview.Disable()
+	var pageLabels []string
+	for _, p := range info.Pages {
+		pageLabels = append(pageLabels, fmt.Sprintf("Pagina %d", p.Number))
+	}
+	pageSelect := widget.NewSelect(pageLabels, func(label string) {
+		for _, p := range info.Pages {
+			if fmt.Sprintf("Pagina %d", p.Number) == label {
+				preview.SetText(p.Text)
+				return
+			}
+		}
+	})
+	if len(pageLabels) > 0 {
+		pageSelect.SetSelected(pageLabels[0])
+	}
+	previewScroll := container.NewVScroll(preview)
+	previewScroll.SetMinSize(fyne.NewSize(0, 180))
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Pagine", rangeEntry),
+		widget.NewFormItem("", summary),
+		widget.NewFormItem("Sezioni", sectionsBox),
+		widget.NewFormItem("Anteprima", container.NewBorder(pageSelect, nil, nil, nil, previewScroll)),
+	}
+	d := dialog.NewForm("Pagine da usare: "+name, "Aggiungi", "Annulla", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		pages, err := selectedPages(rangeEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		onDone(pages)
+	}, w)
+	d.Resize(fyne.NewSize(760, 600))
+	d.Show()
+}
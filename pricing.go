@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// modelPrice is what a model costs, in dollars per token; Image is per
+// input image, for models that bill images separately.
+type modelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+	Image      float64 `json:"image,omitempty"`
+}
+
+// cost prices the tokens of u and the given number of images.
+func (p modelPrice) cost(u Usage, images int) float64 {
+	return float64(u.PromptTokens)*p.Prompt + float64(u.CompletionTokens)*p.Completion + float64(images)*p.Image
+}
+
+// builtinPrices are indicative list prices of common models, used until
+// the OpenRouter model catalog has been downloaded.
+var builtinPrices = map[string]modelPrice{
+	"openai/gpt-4o":               {Prompt: 2.5e-6, Completion: 10e-6},
+	"openai/gpt-4o-mini":          {Prompt: 0.15e-6, Completion: 0.6e-6},
+	"anthropic/claude-3.5-sonnet": {Prompt: 3e-6, Completion: 15e-6},
+	"anthropic/claude-3-haiku":    {Prompt: 0.25e-6, Completion: 1.25e-6},
+	"google/gemini-flash-1.5":     {Prompt: 0.075e-6, Completion: 0.3e-6},
+}
+
+// priceTable maps model IDs to prices. Updated is when the catalog they
+// come from was downloaded, zero for the built-in table.
+type priceTable struct {
+	Updated time.Time             `json:"updated"`
+	Models  map[string]modelPrice `json:"models"`
+}
+
+// lookup finds the price of a model. IDs without a vendor prefix, as used
+// by OpenAI and Anthropic directly, match the prefixed OpenRouter ID.
+func (t priceTable) lookup(model string) (modelPrice, bool) {
+	model = strings.TrimSpace(model)
+	if p, ok := t.Models[model]; ok {
+		return p, true
+	}
+	if strings.Contains(model, "/") {
+		return modelPrice{}, false
+	}
+	for id, p := range t.Models {
+		if strings.HasSuffix(id, "/"+model) {
+			return p, true
+		}
+	}
+	if c := openRouterClaudeID(model); c != model {
+		return t.lookup(c)
+	}
+	return modelPrice{}, false
+}
+
+var (
+	claudeSnapshot = regexp.MustCompile(`-(latest|\d{8})$`)
+	claudeVersion  = regexp.MustCompile(`(\d)-(\d)`)
+)
+
+// openRouterClaudeID turns a native Anthropic model ID into the one
+// OpenRouter uses, without the snapshot date or "-latest" and with a dotted
+// version: "claude-3-5-sonnet-latest" becomes "claude-3.5-sonnet".
+func openRouterClaudeID(model string) string {
+	if !strings.HasPrefix(model, "claude-") {
+		return model
+	}
+	model = claudeSnapshot.ReplaceAllString(model, "")
+	return claudeVersion.ReplaceAllString(model, "$1.$2")
+}
+
+func (t priceTable) stale() bool {
+	return time.Since(t.Updated) > catalogMaxAge
+}
+
+// configDir is where LazyQ keeps its files outside the app preferences,
+// shared with the command line and the REST API.
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "lazyq"), nil
+}
+
+// loadPrices returns the built-in prices, overridden by those in the cached
+// OpenRouter catalog. Models used through other providers are matched by ID.
+func loadPrices() priceTable {
+	t := priceTable{Models: map[string]modelPrice{}}
+	for id, p := range builtinPrices {
+		t.Models[id] = p
+	}
+	c, ok := readCatalog(providerOpenRouter)
+	if !ok {
+		return t
+	}
+	t.Updated = c.Updated
+	for _, m := range c.Models {
+		if m.Price != nil {
+			t.Models[m.ID] = *m.Price
+		}
+	}
+	return t
+}
+
+// Rough sizes for the pre-flight estimate and the context budget (see
+// inputBudget); the real numbers come back with the reply and end up in
+// the ledger.
+const (
+	charsPerToken = 4
+	requestTokens = 800  // instructions and JSON schema, per request
+	imageTokens   = 1000 // per image
+)
+
+// styleReplyTokens is roughly what one question of each style takes in
+// the reply, answer, explanation and source included. Multiple choice
+// carries a rationale per option, essays key points and a rubric.
+var styleReplyTokens = map[string]int{
+	"":                  150,
+	styleMultipleChoice: 350,
+	styleCloze:          200,
+	styleTrueFalse:      120,
+	styleSequence:       250,
+	styleMatching:       300,
+	styleComplex:        300,
+	styleEssay:          700,
+	styleNumbers:        150,
+}
+
+// tokensPerQuestion is the reply size of one question of style.
+func tokensPerQuestion(style string) int {
+	if t, ok := styleReplyTokens[style]; ok {
+		return t
+	}
+	return styleReplyTokens[""]
+}
+
+// estimateUsage guesses what generating from mat will consume, planning
+// the requests the same way generateQuestionSet does. The cost is at the
+// first model's price; priced is false when that price isn't known.
+func estimateUsage(opts generationOptions, mat material, prices priceTable) (u Usage, priced bool) {
+	if len(opts.Mix) > 0 {
+		// One generation per style
+		for _, sh := range opts.Mix {
+			o := opts
+			o.Mix, o.Style, o.N = nil, sh.Style, sh.N
+			su, p := estimateUsage(o, mat, prices)
+			u.Add(su)
+			priced = p
+		}
+		return u, priced
+	}
+	chunks := materialChunks(opts, mat)
+	jobs := []chunkJob{{text: strings.Join(chunks, ""), images: mat.Images, n: min(opts.N, questionsPerRequest(opts.Style))}}
+	if len(chunks) > 1 {
+		jobs = planChunkJobs(opts.N, questionsPerRequest(opts.Style), chunks, mat.Images)
+	}
+	images := 0
+	for _, job := range jobs {
+		u.PromptTokens += requestTokens + utf8.RuneCountInString(job.text)/charsPerToken + len(job.images)*imageTokens
+		u.CompletionTokens += job.n * tokensPerQuestion(opts.Style)
+		images += len(job.images)
+	}
+	price, priced := prices.lookup(opts.Model)
+	if priced {
+		u.Cost = price.cost(u, images)
+	}
+	return u, priced
+}
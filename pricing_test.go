@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestPriceLookup(t *testing.T) {
+	sonnet := builtinPrices["anthropic/claude-3.5-sonnet"]
+	haiku := builtinPrices["anthropic/claude-3-haiku"]
+	gpt := builtinPrices["openai/gpt-4o"]
+	table := priceTable{Models: builtinPrices}
+	tests := []struct {
+		model string
+		want  modelPrice
+		ok    bool
+	}{
+		{"openai/gpt-4o", gpt, true},
+		{" gpt-4o ", gpt, true},
+		{"anthropic/claude-3.5-sonnet", sonnet, true},
+		{"claude-3.5-sonnet", sonnet, true},
+		{"claude-3-5-sonnet-latest", sonnet, true},
+		{"claude-3-5-sonnet-20241022", sonnet, true},
+		{"claude-3-haiku-20240307", haiku, true},
+		{"claude-3-opus-latest", modelPrice{}, false},
+		{"mistral/gpt-4o", modelPrice{}, false},
+		{"llama3.2-vision", modelPrice{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got, ok := table.lookup(tt.model)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("lookup(%q) = %v, %v, want %v, %v", tt.model, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestOpenRouterClaudeID(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"claude-3-5-sonnet-latest", "claude-3.5-sonnet"},
+		{"claude-3-7-sonnet-20250219", "claude-3.7-sonnet"},
+		{"claude-sonnet-4-5", "claude-sonnet-4.5"},
+		{"claude-3-haiku-20240307", "claude-3-haiku"},
+		{"claude-3.5-sonnet", "claude-3.5-sonnet"},
+		{"gpt-4o-2024-08-06", "gpt-4o-2024-08-06"},
+	}
+	for _, tt := range tests {
+		if got := openRouterClaudeID(tt.in); got != tt.want {
+			t.Errorf("openRouterClaudeID(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Provider kinds, as stored in the preferences
+const (
+	providerOpenRouter = "openrouter"
+	providerOpenAI     = "openai"
+	providerOllama     = "ollama"
+	providerLlamaCpp   = "llamacpp"
+	providerAnthropic  = "anthropic"
+)
+
+const (
+	openAIBaseURL    = "https://api.openai.com/v1"
+	ollamaBaseURL    = "http://localhost:11434"
+	llamaCppBaseURL  = "http://localhost:8080/v1"
+	anthropicBaseURL = "https://api.anthropic.com/v1"
+	anthropicVersion = "2023-06-01"
+	anthropicTokens  = 8192
+)
+
+// providerKinds is the order in which providers are offered in the API key screen.
+var providerKinds = []string{providerOpenRouter, providerOpenAI, providerOllama, providerLlamaCpp, providerAnthropic}
+
+var providerLabels = map[string]string{
+	providerOpenRouter: "OpenRouter",
+	providerOpenAI:     "Compatibile OpenAI",
+	providerOllama:     "Ollama",
+	providerLlamaCpp:   "llama.cpp",
+	providerAnthropic:  "Anthropic",
+}
+
+// Provider is a chat completion backend accepting text and image parts.
+type Provider interface {
+	Name() string
+	Complete(ctx context.Context, req completionRequest) (completionResponse, error)
+}
+
+// completionRequest is the provider-neutral form of a single chat turn:
+// a system prompt plus one user message made of text and image parts.
+type completionRequest struct {
+	Model       string
+	System      string
+	Parts       []contentPart
+	Temperature float64
+	Schema      *jsonSchema // optional structured output; ignored by providers without support
+	// ContextLength is the context window the material was sized for, in
+	// tokens, 0 for the default; Ollama allocates it, since its own default
+	// is smaller. Others ignore it.
+	ContextLength int
+	// OnDelta, when set, makes the provider stream the reply and receive
+	// each piece of text as it arrives. Content still holds the whole reply.
+	OnDelta func(text string)
+}
+
+type completionResponse struct {
+	Content      string
+	FinishReason string
+	Model        string // the model that answered, when the provider says
+	Usage        Usage  // tokens and cost, as far as the provider reports them
+}
+
+// providerConfig holds everything needed to build a Provider.
+type providerConfig struct {
+	Kind    string
+	BaseURL string // empty means the provider default
+	APIKey  string
+	HTTP    httpSettings
+}
+
+func newProvider(cfg providerConfig) (Provider, error) {
+	base := cfg.baseURL()
+	if cfg.needsKey() && strings.TrimSpace(cfg.APIKey) == "" {
+		return nil, fmt.Errorf("%s requires an API key", providerLabel(cfg.Kind))
+	}
+	var defaults map[string]string
+	if cfg.Kind == providerOpenRouter || cfg.Kind == "" {
+		defaults = map[string]string{"HTTP-Referer": refererHeader, "X-Title": xTitleHeader}
+	}
+	ep, err := newEndpoint(cfg.HTTP, defaults)
+	if err != nil {
+		return nil, err
+	}
+	var p Provider
+	switch cfg.Kind {
+	case providerOpenRouter, "":
+		p = &openAIProvider{name: "OpenRouter", url: base + "/chat/completions", apiKey: cfg.APIKey, ep: ep}
+	case providerOpenAI:
+		p = &openAIProvider{name: "OpenAI", url: base + "/chat/completions", apiKey: cfg.APIKey, ep: ep}
+	case providerOllama:
+		p = &ollamaProvider{url: base + "/api/chat", ep: ep}
+	case providerLlamaCpp:
+		p = &openAIProvider{name: "llama.cpp", url: base + "/chat/completions", apiKey: cfg.APIKey, ep: ep}
+	case providerAnthropic:
+		p = &anthropicProvider{url: base + "/messages", apiKey: cfg.APIKey, ep: ep}
+	default:
+		return nil, fmt.Errorf("unknown provider %q", cfg.Kind)
+	}
+	attempts := cfg.HTTP.Attempts
+	if attempts <= 0 {
+		attempts = defaultAttempts
+	}
+	return &retryingProvider{Provider: p, attempts: attempts}, nil
+}
+
+func (cfg providerConfig) baseURL() string {
+	base := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if base == "" {
+		base = defaultBaseURL(cfg.Kind)
+	}
+	return base
+}
+
+// needsKey reports whether an API key is mandatory. Local servers never
+// require one, even when they speak a cloud provider's protocol.
+func (cfg providerConfig) needsKey() bool {
+	return providerNeedsKey(cfg.Kind) && !isLocalEndpoint(cfg.baseURL())
+}
+
+func providerLabel(kind string) string {
+	if l, ok := providerLabels[kind]; ok {
+		return l
+	}
+	return providerLabels[providerOpenRouter]
+}
+
+func providerKindFromLabel(label string) string {
+	for k, l := range providerLabels {
+		if l == label {
+			return k
+		}
+	}
+	return providerOpenRouter
+}
+
+func providerNeedsKey(kind string) bool {
+	return kind != providerOllama && kind != providerLlamaCpp
+}
+
+func defaultBaseURL(kind string) string {
+	switch kind {
+	case providerOpenAI:
+		return openAIBaseURL
+	case providerOllama:
+		return ollamaBaseURL
+	case providerLlamaCpp:
+		return llamaCppBaseURL
+	case providerAnthropic:
+		return anthropicBaseURL
+	}
+	return openRouterBaseURL
+}
+
+func defaultModelFor(kind string) string {
+	switch kind {
+	case providerOpenAI:
+		return "gpt-4o"
+	case providerOllama:
+		return "llama3.2-vision"
+	case providerLlamaCpp:
+		return "local" // llama-server serves a single model and ignores the name
+	case providerAnthropic:
+		return "claude-3-5-sonnet-latest"
+	}
+	return defaultModel
+}
+
+// splitDataURL returns the media type and base64 payload of a data URL.
+func splitDataURL(du string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(du, "data:")
+	if !ok {
+		return "", "", false
+	}
+	meta, data, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", "", false
+	}
+	mtyp, ok := strings.CutSuffix(meta, ";base64")
+	if !ok {
+		return "", "", false
+	}
+	return mtyp, data, true
+}
+
+// OpenAI-compatible chat completions (OpenRouter, OpenAI, and friends)
+
+type contentPart struct {
+	Type     string    `json:"type"`                // "text" or "image_url"
+	Text     string    `json:"text,omitempty"`      // for text
+	ImageURL *imageURL `json:"image_url,omitempty"` // for image
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+type message struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"` // string or []contentPart
+}
+
+type chatRequest struct {
+	Model          string          `json:"model"`
+	Messages       []message       `json:"messages"`
+	Temperature    float64         `json:"temperature,omitempty"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+	Stream         bool            `json:"stream,omitempty"`
+	StreamOptions  *streamOptions  `json:"stream_options,omitempty"`
+	Usage          *usageOptions   `json:"usage,omitempty"` // OpenRouter: report the cost
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type usageOptions struct {
+	Include bool `json:"include"`
+}
+
+// chatUsage is the usage block of an OpenAI-style reply. Cost is only sent
+// by OpenRouter, in dollars.
+type chatUsage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (u *chatUsage) usage() Usage {
+	if u == nil {
+		return Usage{}
+	}
+	return Usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, Cost: u.Cost}
+}
+
+type responseFormat struct {
+	Type       string           `json:"type"` // "json_schema"
+	JSONSchema *namedJSONSchema `json:"json_schema,omitempty"`
+}
+
+type namedJSONSchema struct {
+	Name   string                 `json:"name"`
+	Strict bool                   `json:"strict"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+type chatResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index   int `json:"index"`
+		Message struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error,omitempty"`
+}
+
+// chatStreamChunk is one server-sent event of a streamed chat completion.
+// The usage block comes with the last one.
+type chatStreamChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error,omitempty"`
+}
+
+type openAIProvider struct {
+	name   string
+	url    string
+	apiKey string
+	ep     endpoint
+}
+
+func (p *openAIProvider) Name() string { return p.name }
+
+func (p *openAIProvider) Complete(ctx context.Context, req completionRequest) (completionResponse, error) {
+	reqBody := chatRequest{
+		Model: req.Model,
+		Messages: []message{
+			{Role: "system", Content: req.System},
+			{Role: "user", Content: req.Parts},
+		},
+		Temperature: req.Temperature,
+	}
+	if p.name == "OpenRouter" {
+		reqBody.Usage = &usageOptions{Include: true}
+	}
+	if req.Schema != nil {
+		reqBody.ResponseFormat = &responseFormat{
+			Type:       "json_schema",
+			JSONSchema: &namedJSONSchema{Name: req.Schema.Name, Strict: true, Schema: req.Schema.Schema},
+		}
+	}
+
+	headers := map[string]string{}
+	if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+
+	var cr chatResponse
+	if req.OnDelta != nil {
+		reqBody.Stream = true
+		reqBody.StreamOptions = &streamOptions{IncludeUsage: true}
+		var out completionResponse
+		var id string
+		var content strings.Builder
+		plain, err := p.ep.postStream(ctx, p.name, p.url, headers, reqBody, func(line string) error {
+			data, ok := strings.CutPrefix(line, "data:")
+			data = strings.TrimSpace(data)
+			if !ok || data == "" || data == "[DONE]" {
+				return nil // comments, keep-alives and the end marker
+			}
+			var chunk chatStreamChunk
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return fmt.Errorf("failed to decode stream: %v\nRaw: %s", err, truncate(data, 800))
+			}
+			if chunk.Error != nil {
+				return fmt.Errorf("%s error: %s (%s)", p.name, chunk.Error.Message, chunk.Error.Type)
+			}
+			if chunk.Model != "" {
+				out.Model = chunk.Model
+			}
+			if chunk.ID != "" {
+				id = chunk.ID
+			}
+			if chunk.Usage != nil {
+				out.Usage = chunk.Usage.usage()
+			}
+			for _, c := range chunk.Choices {
+				if c.Delta.Content != "" {
+					content.WriteString(c.Delta.Content)
+					req.OnDelta(c.Delta.Content)
+				}
+				if c.FinishReason != "" {
+					out.FinishReason = c.FinishReason
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return completionResponse{}, err
+		}
+		if plain == nil {
+			out.Content = content.String()
+			p.fillCost(ctx, id, &out.Usage)
+			return out, nil
+		}
+		if err := json.Unmarshal(plain, &cr); err != nil {
+			return completionResponse{}, fmt.Errorf("failed to decode response: %v\nRaw: %s", err, truncate(string(plain), 800))
+		}
+	} else if err := p.ep.postJSON(ctx, p.name, p.url, headers, reqBody, &cr); err != nil {
+		return completionResponse{}, err
+	}
+	if cr.Error != nil {
+		return completionResponse{}, fmt.Errorf("%s error: %s (%s)", p.name, cr.Error.Message, cr.Error.Type)
+	}
+	if len(cr.Choices) == 0 {
+		return completionResponse{}, fmt.Errorf("no choices returned")
+	}
+	out := completionResponse{
+		Content:      cr.Choices[0].Message.Content,
+		FinishReason: cr.Choices[0].FinishReason,
+		Model:        cr.Model,
+		Usage:        cr.Usage.usage(),
+	}
+	p.fillCost(ctx, cr.ID, &out.Usage)
+	return out, nil
+}
+
+// fillCost asks OpenRouter's generation stats for the cost of a reply whose
+// usage block didn't include it. The stats appear a moment after the reply,
+// so a miss is retried briefly; failing that the cost stays unknown.
+func (p *openAIProvider) fillCost(ctx context.Context, id string, u *Usage) {
+	if p.name != "OpenRouter" || id == "" || u.Cost > 0 {
+		return
+	}
+	statsURL := strings.TrimSuffix(p.url, "/chat/completions") + "/generation?id=" + url.QueryEscape(id)
+	headers := map[string]string{"Authorization": "Bearer " + p.apiKey}
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+		var stats struct {
+			Data struct {
+				TotalCost        float64 `json:"total_cost"`
+				TokensPrompt     int     `json:"tokens_prompt"`
+				TokensCompletion int     `json:"tokens_completion"`
+			} `json:"data"`
+		}
+		if err := p.ep.getJSON(ctx, p.name, statsURL, headers, &stats); err != nil {
+			continue
+		}
+		u.Cost = stats.Data.TotalCost
+		if u.PromptTokens == 0 && u.CompletionTokens == 0 {
+			u.PromptTokens = stats.Data.TokensPrompt
+			u.CompletionTokens = stats.Data.TokensCompletion
+		}
+		return
+	}
+}
+
+// Ollama native chat API
+
+type ollamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"` // raw base64, no data URL prefix
+}
+
+type ollamaRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Stream   bool                   `json:"stream"`
+	Format   interface{}            `json:"format,omitempty"` // JSON schema for structured output
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+type ollamaResponse struct {
+	Message    ollamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason"`
+	Error      string        `json:"error,omitempty"`
+	// Token counts, sent with the last message
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+func (r ollamaResponse) usage() Usage {
+	return Usage{PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount}
+}
+
+type ollamaProvider struct {
+	url string
+	ep  endpoint
+}
+
+func (p *ollamaProvider) Name() string { return "Ollama" }
+
+func (p *ollamaProvider) Complete(ctx context.Context, req completionRequest) (completionResponse, error) {
+	user := ollamaMessage{Role: "user"}
+	var texts []string
+	for _, part := range req.Parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			if _, data, ok := splitDataURL(part.ImageURL.URL); ok {
+				user.Images = append(user.Images, data)
+			}
+		}
+	}
+	user.Content = strings.Join(texts, "\n\n")
+
+	reqBody := ollamaRequest{
+		Model: req.Model,
+		Messages: []ollamaMessage{
+			{Role: "system", Content: req.System},
+			user,
+		},
+		Options: map[string]interface{}{"temperature": req.Temperature, "num_ctx": defaultContextTokens},
+	}
+	if req.ContextLength > 0 {
+		reqBody.Options["num_ctx"] = req.ContextLength
+	}
+	if req.Schema != nil {
+		reqBody.Format = req.Schema.Schema
+	}
+
+	var or ollamaResponse
+	if req.OnDelta != nil {
+		// Streamed replies are one JSON object per line, the last with done set
+		reqBody.Stream = true
+		var content strings.Builder
+		plain, err := p.ep.postStream(ctx, p.Name(), p.url, nil, reqBody, func(line string) error {
+			if strings.TrimSpace(line) == "" {
+				return nil
+			}
+			var chunk ollamaResponse
+			if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+				return fmt.Errorf("failed to decode stream: %v\nRaw: %s", err, truncate(line, 800))
+			}
+			if chunk.Error != "" {
+				return fmt.Errorf("Ollama error: %s", chunk.Error)
+			}
+			if chunk.Message.Content != "" {
+				content.WriteString(chunk.Message.Content)
+				req.OnDelta(chunk.Message.Content)
+			}
+			if chunk.Done {
+				or.DoneReason = chunk.DoneReason
+				or.PromptEvalCount = chunk.PromptEvalCount
+				or.EvalCount = chunk.EvalCount
+			}
+			return nil
+		})
+		if err != nil {
+			return completionResponse{}, err
+		}
+		if plain == nil {
+			return completionResponse{Content: content.String(), FinishReason: or.DoneReason, Usage: or.usage()}, nil
+		}
+		if err := json.Unmarshal(plain, &or); err != nil {
+			return completionResponse{}, fmt.Errorf("failed to decode response: %v\nRaw: %s", err, truncate(string(plain), 800))
+		}
+	} else if err := p.ep.postJSON(ctx, p.Name(), p.url, nil, reqBody, &or); err != nil {
+		return completionResponse{}, err
+	}
+	if or.Error != "" {
+		return completionResponse{}, fmt.Errorf("Ollama error: %s", or.Error)
+	}
+	return completionResponse{Content: or.Message.Content, FinishReason: or.DoneReason, Usage: or.usage()}, nil
+}
+
+// Anthropic Messages API
+
+type anthropicSource struct {
+	Type      string `json:"type"` // "base64"
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicBlock struct {
+	Type   string           `json:"type"` // "text" or "image"
+	Text   string           `json:"text,omitempty"`
+	Source *anthropicSource `json:"source,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float64            `json:"temperature,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+	Error      *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// anthropicEvent is one server-sent event of a streamed message; only the
+// text deltas, the stop reason, token counts and errors matter here. Input
+// tokens come with message_start, output tokens with message_delta.
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type anthropicProvider struct {
+	url    string
+	apiKey string
+	ep     endpoint
+}
+
+func (p *anthropicProvider) Name() string { return "Anthropic" }
+
+func (p *anthropicProvider) Complete(ctx context.Context, req completionRequest) (completionResponse, error) {
+	var blocks []anthropicBlock
+	for _, part := range req.Parts {
+		switch part.Type {
+		case "text":
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+		case "image_url":
+			if mtyp, data, ok := splitDataURL(part.ImageURL.URL); ok {
+				blocks = append(blocks, anthropicBlock{
+					Type:   "image",
+					Source: &anthropicSource{Type: "base64", MediaType: mtyp, Data: data},
+				})
+			}
+		}
+	}
+
+	reqBody := anthropicRequest{
+		Model:       req.Model,
+		System:      req.System,
+		Messages:    []anthropicMessage{{Role: "user", Content: blocks}},
+		MaxTokens:   anthropicTokens,
+		Temperature: req.Temperature,
+	}
+	headers := map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": anthropicVersion,
+	}
+
+	var ar anthropicResponse
+	if req.OnDelta != nil {
+		reqBody.Stream = true
+		var content strings.Builder
+		plain, err := p.ep.postStream(ctx, p.Name(), p.url, headers, reqBody, func(line string) error {
+			data, ok := strings.CutPrefix(line, "data:")
+			if !ok {
+				return nil // event names and blank separators
+			}
+			var ev anthropicEvent
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &ev); err != nil {
+				return fmt.Errorf("failed to decode stream: %v\nRaw: %s", err, truncate(data, 800))
+			}
+			switch {
+			case ev.Error != nil:
+				return fmt.Errorf("Anthropic error: %s (%s)", ev.Error.Message, ev.Error.Type)
+			case ev.Type == "content_block_delta" && ev.Delta.Type == "text_delta":
+				content.WriteString(ev.Delta.Text)
+				req.OnDelta(ev.Delta.Text)
+			case ev.Type == "message_start":
+				ar.Usage.InputTokens = ev.Message.Usage.InputTokens
+			case ev.Type == "message_delta":
+				ar.StopReason = ev.Delta.StopReason
+				ar.Usage.OutputTokens = ev.Usage.OutputTokens
+			}
+			return nil
+		})
+		if err != nil {
+			return completionResponse{}, err
+		}
+		if plain == nil {
+			return completionResponse{Content: content.String(), FinishReason: ar.StopReason, Usage: ar.Usage.usage()}, nil
+		}
+		if err := json.Unmarshal(plain, &ar); err != nil {
+			return completionResponse{}, fmt.Errorf("failed to decode response: %v\nRaw: %s", err, truncate(string(plain), 800))
+		}
+	} else if err := p.ep.postJSON(ctx, p.Name(), p.url, headers, reqBody, &ar); err != nil {
+		return completionResponse{}, err
+	}
+	if ar.Error != nil {
+		return completionResponse{}, fmt.Errorf("Anthropic error: %s (%s)", ar.Error.Message, ar.Error.Type)
+	}
+	var b strings.Builder
+	for _, blk := range ar.Content {
+		if blk.Type == "text" {
+			b.WriteString(blk.Text)
+		}
+	}
+	return completionResponse{Content: b.String(), FinishReason: ar.StopReason, Usage: ar.Usage.usage()}, nil
+}
+
+func (u anthropicUsage) usage() Usage {
+	return Usage{PromptTokens: u.InputTokens, CompletionTokens: u.OutputTokens}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Difficulty levels, as requested from the model
+const (
+	difficultyEasy   = "facile"
+	difficultyMedium = "media"
+	difficultyHard   = "difficile"
+)
+
+// Source points back to where a question comes from in the material.
+type Source struct {
+	File  string `json:"file,omitempty"`
+	Page  int    `json:"page,omitempty"`  // 1-based, 0 when unknown
+	Quote string `json:"quote,omitempty"` // passage or section the question is based on
+}
+
+func (s Source) String() string {
+	var parts []string
+	if s.File != "" {
+		parts = append(parts, s.File)
+	}
+	if s.Page > 0 {
+		parts = append(parts, fmt.Sprintf("p. %d", s.Page))
+	}
+	if s.Quote != "" {
+		parts = append(parts, s.Quote)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Choice is one option of a multiple choice question. Every option carries
+// its rationale: why it is right, or why the distractor is plausible but wrong.
+type Choice struct {
+	Text      string `json:"text"`
+	Correct   bool   `json:"correct,omitempty"`
+	Rationale string `json:"rationale,omitempty"`
+}
+
+// Blank is one gap of a cloze question, with the spellings and synonyms
+// that are accepted besides the exact answer.
+type Blank struct {
+	Answer       string   `json:"answer"`
+	Alternatives []string `json:"alternatives,omitempty"`
+}
+
+// Pair is one correct match of a matching question, such as a term and
+// its definition or a cause and its effect.
+type Pair struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// Criterion is one row of the grading rubric of an essay question.
+type Criterion struct {
+	Name   string        `json:"name"`
+	Levels []RubricLevel `json:"levels"` // best first
+}
+
+// RubricLevel is what an answer must do to earn Points on a criterion.
+type RubricLevel struct {
+	Points      float64 `json:"points"`
+	Description string  `json:"description"`
+}
+
+// blankMarker stands for a gap in the stem of a cloze question.
+const blankMarker = "_____"
+
+// Question is a single generated question. It is shared by the UI, the
+// save and export code and the quiz, so none of them re-parse numbered text.
+type Question struct {
+	ID          int            `json:"id"`
+	Type        string         `json:"type"`
+	Stem        string         `json:"stem"`
+	Options     []string       `json:"options,omitempty"`
+	Choices     []Choice       `json:"choices,omitempty"`    // multiple choice only, with exactly one correct
+	Blanks      []Blank        `json:"blanks,omitempty"`     // cloze only, one per blankMarker in Stem
+	Pairs       []Pair         `json:"pairs,omitempty"`      // matching only, in matching order
+	Sequence    []string       `json:"sequence,omitempty"`   // sequence only, the items in their correct order
+	Numeric     *NumericAnswer `json:"numeric,omitempty"`    // numeric only
+	KeyPoints   []string       `json:"key_points,omitempty"` // essay only, what a good answer covers
+	Rubric      []Criterion    `json:"rubric,omitempty"`     // essay only
+	Answer      string         `json:"answer"`
+	Explanation string         `json:"explanation,omitempty"`
+	Difficulty  string         `json:"difficulty,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	Source      Source         `json:"source"`
+}
+
+// CorrectChoice returns the index of the correct choice, or -1 if the
+// question has none.
+func (q Question) CorrectChoice() int {
+	for i, c := range q.Choices {
+		if c.Correct {
+			return i
+		}
+	}
+	return -1
+}
+
+// GradeChoice reports whether choice i is the correct one.
+func (q Question) GradeChoice(i int) bool {
+	return i >= 0 && i == q.CorrectChoice()
+}
+
+// StemText is the stem as printed. Gaps are numbered when there is more
+// than one, so that answers can refer to them.
+func (q Question) StemText() string {
+	stem := strings.TrimSpace(q.Stem)
+	if len(q.Blanks) < 2 {
+		return stem
+	}
+	parts := strings.Split(stem, blankMarker)
+	var b strings.Builder
+	for i, p := range parts {
+		b.WriteString(p)
+		if i < len(parts)-1 {
+			fmt.Fprintf(&b, "%s(%d)", blankMarker, i+1)
+		}
+	}
+	return b.String()
+}
+
+// MaxPoints is the top score of the rubric of an essay question.
+func (q Question) MaxPoints() float64 {
+	total := 0.0
+	for _, c := range q.Rubric {
+		if len(c.Levels) > 0 {
+			total += c.Levels[0].Points
+		}
+	}
+	return total
+}
+
+// RightOrder is the order in which the right column of a matching question
+// is printed, as indexes into Pairs. It is shuffled, but always the same for
+// the same question so that printed questions and answers agree.
+func (q Question) RightOrder() []int {
+	lefts := make([]string, len(q.Pairs))
+	for i, p := range q.Pairs {
+		lefts[i] = p.Left
+	}
+	return stablePerm(q.Stem, lefts)
+}
+
+// SequenceOrder is the order in which the items of a sequence question are
+// printed, as indexes into Sequence; like RightOrder, shuffled but stable.
+func (q Question) SequenceOrder() []int {
+	return stablePerm(q.Stem, q.Sequence)
+}
+
+// stablePerm shuffles len(items) indexes with a seed taken from the
+// question, never leaving them in their original order.
+func stablePerm(stem string, items []string) []int {
+	h := fnv.New64a()
+	h.Write([]byte(stem))
+	for _, it := range items {
+		h.Write([]byte(it))
+	}
+	order := rand.New(rand.NewPCG(h.Sum64(), 0)).Perm(len(items))
+	if slices.IsSorted(order) && len(order) > 1 {
+		// Unshuffled by chance: the answer would be given away
+		order = append(order[1:], order[0])
+	}
+	return order
+}
+
+// essayGuide lists the key points and the rubric of an essay question,
+// each line starting with indent.
+func (q Question) essayGuide(indent string) string {
+	var b strings.Builder
+	if len(q.KeyPoints) > 0 {
+		fmt.Fprintf(&b, "%sPunti chiave:\n", indent)
+		for _, kp := range q.KeyPoints {
+			fmt.Fprintf(&b, "%s- %s\n", indent, kp)
+		}
+	}
+	if len(q.Rubric) > 0 {
+		fmt.Fprintf(&b, "%sGriglia di valutazione (massimo %s):\n", indent, pointsText(q.MaxPoints()))
+		for _, c := range q.Rubric {
+			fmt.Fprintf(&b, "%s%s\n", indent, c.Name)
+			for _, l := range c.Levels {
+				fmt.Fprintf(&b, "%s  %s: %s\n", indent, pointsText(l.Points), l.Description)
+			}
+		}
+	}
+	return b.String()
+}
+
+func pointsText(p float64) string {
+	if p == 1 {
+		return "1 punto"
+	}
+	return formatNumber(p) + " punti"
+}
+
+// choiceLabel is the letter a choice is listed with, as in "b)".
+func choiceLabel(i int) string {
+	return fmt.Sprintf("%c)", 'a'+i)
+}
+
+// QuestionSet is the result of one generation.
+type QuestionSet struct {
+	Style     string     `json:"style,omitempty"`
+	Model     string     `json:"model,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	Usage     *Usage     `json:"usage,omitempty"`         // what the generation consumed, when known
+	Parts     int        `json:"parts,omitempty"`         // how many parts long material was split into
+	Skipped   int        `json:"skipped_parts,omitempty"` // parts left out, when there are fewer questions than parts
+	Requested int        `json:"requested,omitempty"`     // questions asked for, when fewer came back
+	Questions []Question `json:"questions"`
+}
+
+// Renumber assigns sequential IDs starting from 1.
+func (qs *QuestionSet) Renumber() {
+	for i := range qs.Questions {
+		qs.Questions[i].ID = i + 1
+	}
+}
+
+// ShuffleChoices puts the choices of every multiple choice question in
+// random order, since models tend to list the correct one first.
+func (qs *QuestionSet) ShuffleChoices() {
+	for i := range qs.Questions {
+		c := qs.Questions[i].Choices
+		rand.Shuffle(len(c), func(a, b int) { c[a], c[b] = c[b], c[a] })
+	}
+}
+
+// QuestionsText renders the numbered questions without answers.
+func (qs *QuestionSet) QuestionsText() string {
+	var b strings.Builder
+	for _, q := range qs.Questions {
+		fmt.Fprintf(&b, "%d. %s\n", q.ID, q.StemText())
+		for j, opt := range q.Options {
+			fmt.Fprintf(&b, "   %s %s\n", choiceLabel(j), strings.TrimSpace(opt))
+		}
+		for j, c := range q.Choices {
+			fmt.Fprintf(&b, "   %s %s\n", choiceLabel(j), strings.TrimSpace(c.Text))
+		}
+		if len(q.Pairs) > 0 {
+			for j, p := range q.Pairs {
+				fmt.Fprintf(&b, "   %d) %s\n", j+1, p.Left)
+			}
+			for j, k := range q.RightOrder() {
+				fmt.Fprintf(&b, "   %s %s\n", choiceLabel(j), q.Pairs[k].Right)
+			}
+		}
+		for j, k := range q.SequenceOrder() {
+			fmt.Fprintf(&b, "   %s %s\n", choiceLabel(j), q.Sequence[k])
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// AnswersText renders the numbered answers with explanation and source.
+// Multiple choice answers give the letter of the key and the rationale of
+// every option.
+func (qs *QuestionSet) AnswersText() string {
+	var b strings.Builder
+	for _, q := range qs.Questions {
+		answer := strings.TrimSpace(q.Answer)
+		if k := q.CorrectChoice(); k >= 0 {
+			answer = choiceLabel(k) + " " + strings.TrimSpace(q.Choices[k].Text)
+		}
+		if len(q.Pairs) > 0 {
+			letters := make([]string, len(q.Pairs))
+			for j, k := range q.RightOrder() {
+				letters[k] = choiceLabel(j)[:1]
+			}
+			var matches []string
+			for j := range q.Pairs {
+				matches = append(matches, fmt.Sprintf("%d-%s", j+1, letters[j]))
+			}
+			answer = strings.Join(matches, ", ")
+		}
+		if len(q.Sequence) > 0 {
+			letters := make([]string, len(q.Sequence))
+			for j, k := range q.SequenceOrder() {
+				letters[k] = choiceLabel(j)[:1]
+			}
+			answer = strings.Join(letters, ", ") + " (" + strings.Join(q.Sequence, " → ") + ")"
+		}
+		if len(q.Blanks) > 1 {
+			var answers []string
+			for j, bl := range q.Blanks {
+				answers = append(answers, fmt.Sprintf("(%d) %s", j+1, bl.Answer))
+			}
+			answer = strings.Join(answers, "; ")
+		}
+		if q.Numeric != nil {
+			if tol := q.Numeric.ToleranceText(); tol != "" {
+				answer += " (tolleranza " + tol + ")"
+			}
+		}
+		fmt.Fprintf(&b, "%d. %s\n", q.ID, answer)
+		var alternatives []string
+		for _, bl := range q.Blanks {
+			alternatives = append(alternatives, bl.Alternatives...)
+		}
+		if len(alternatives) > 0 {
+			fmt.Fprintf(&b, "   Accettate anche: %s\n", strings.Join(alternatives, ", "))
+		}
+		if e := strings.TrimSpace(q.Explanation); e != "" {
+			fmt.Fprintf(&b, "   %s\n", e)
+		}
+		for j, c := range q.Choices {
+			if r := strings.TrimSpace(c.Rationale); r != "" {
+				fmt.Fprintf(&b, "   %s %s\n", choiceLabel(j), r)
+			}
+		}
+		b.WriteString(q.essayGuide("   "))
+		if src := q.Source.String(); src != "" {
+			fmt.Fprintf(&b, "   (Fonte: %s)\n", src)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Text is the plain-text form written by "Salva Domande".
+func (qs *QuestionSet) Text() string {
+	return qs.QuestionsText() + "\n\n=== RISPOSTE ===\n\n" + qs.AnswersText()
+}
+
+func (qs *QuestionSet) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(qs)
+}
+
+func readQuestionSet(r io.Reader) (*QuestionSet, error) {
+	var qs QuestionSet
+	if err := json.NewDecoder(r).Decode(&qs); err != nil {
+		return nil, fmt.Errorf("invalid question set: %w", err)
+	}
+	if len(qs.Questions) == 0 {
+		return nil, fmt.Errorf("invalid question set: no questions")
+	}
+	return &qs, nil
+}
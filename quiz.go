@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// normalizeAnswer prepares a typed answer for comparison: case, repeated
+// spaces and surrounding punctuation don't count, accents do.
+func normalizeAnswer(s string) string {
+	s = strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	})
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// CheckBlank reports whether response fills gap i of a cloze question,
+// with the exact answer or one of the accepted alternatives.
+func (q Question) CheckBlank(i int, response string) bool {
+	if i < 0 || i >= len(q.Blanks) {
+		return false
+	}
+	got := normalizeAnswer(response)
+	if got == "" {
+		return false
+	}
+	bl := q.Blanks[i]
+	for _, want := range append([]string{bl.Answer}, bl.Alternatives...) {
+		if normalizeAnswer(want) == got {
+			return true
+		}
+	}
+	return false
+}
+
+// GradeBlanks is the fraction of the gaps filled correctly, one response
+// per gap in order.
+func (q Question) GradeBlanks(responses []string) float64 {
+	if len(q.Blanks) == 0 {
+		return 0
+	}
+	right := 0
+	for i, r := range responses {
+		if q.CheckBlank(i, r) {
+			right++
+		}
+	}
+	return float64(right) / float64(len(q.Blanks))
+}
+
+// CheckPair reports whether right is the item that matches left item i of
+// a matching question.
+func (q Question) CheckPair(i int, right string) bool {
+	if i < 0 || i >= len(q.Pairs) || strings.TrimSpace(right) == "" {
+		return false
+	}
+	return normalizeAnswer(right) == normalizeAnswer(q.Pairs[i].Right)
+}
+
+// GradeMatching is the fraction of the pairs matched correctly, given for
+// each left item, in order, the right item the student picked.
+func (q Question) GradeMatching(rights []string) float64 {
+	if len(q.Pairs) == 0 {
+		return 0
+	}
+	right := 0
+	for i, r := range rights {
+		if q.CheckPair(i, r) {
+			right++
+		}
+	}
+	return float64(right) / float64(len(q.Pairs))
+}
+
+// GradeOrder scores the order in which the student put the items of a
+// sequence question, with partial credit: the fraction of item pairs in the
+// right relative order, that is 1 minus the normalized Kendall tau
+// distance. Items left out count as misplaced.
+func (q Question) GradeOrder(order []string) float64 {
+	n := len(q.Sequence)
+	if n == 0 {
+		return 0
+	}
+	pos := map[string]int{}
+	for i, item := range order {
+		if _, dup := pos[normalizeAnswer(item)]; !dup {
+			pos[normalizeAnswer(item)] = i
+		}
+	}
+	if n == 1 {
+		if _, ok := pos[normalizeAnswer(q.Sequence[0])]; ok {
+			return 1
+		}
+		return 0
+	}
+	concordant := 0
+	for i := 0; i < n; i++ {
+		pi, ok := pos[normalizeAnswer(q.Sequence[i])]
+		if !ok {
+			continue
+		}
+		for j := i + 1; j < n; j++ {
+			if pj, ok := pos[normalizeAnswer(q.Sequence[j])]; ok && pi < pj {
+				concordant++
+			}
+		}
+	}
+	return float64(concordant) / float64(n*(n-1)/2)
+}
+
+// trueFalseKey returns the answer of a true or false question as a bool;
+// ok is false when the answer is neither "Vero" nor "Falso".
+func (q Question) trueFalseKey() (value, ok bool) {
+	switch normalizeAnswer(q.Answer) {
+	case "vero", "true":
+		return true, true
+	case "falso", "false":
+		return false, true
+	}
+	return false, false
+}
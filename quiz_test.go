@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGradeOrder(t *testing.T) {
+	q := Question{Type: typeSequence, Sequence: []string{"Monarchia", "Repubblica", "Principato", "Dominato"}}
+	tests := []struct {
+		name  string
+		order []string
+		want  float64
+	}{
+		{"identity", []string{"Monarchia", "Repubblica", "Principato", "Dominato"}, 1},
+		{"reversed", []string{"Dominato", "Principato", "Repubblica", "Monarchia"}, 0},
+		{"one adjacent swap", []string{"Repubblica", "Monarchia", "Principato", "Dominato"}, 5.0 / 6},
+		{"first and last swapped", []string{"Dominato", "Repubblica", "Principato", "Monarchia"}, 1.0 / 6},
+		{"case and spacing don't count", []string{"monarchia", " Repubblica ", "PRINCIPATO", "Dominato."}, 1},
+		{"item left out", []string{"Monarchia", "Repubblica", "Principato"}, 3.0 / 6},
+		{"repeated item counts once", []string{"Monarchia", "Monarchia", "Repubblica", "Principato", "Dominato"}, 1},
+		{"empty", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := q.GradeOrder(tt.order); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("GradeOrder(%q) = %v, want %v", tt.order, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("single item", func(t *testing.T) {
+		one := Question{Sequence: []string{"Solo"}}
+		if got := one.GradeOrder([]string{"solo"}); got != 1 {
+			t.Errorf("GradeOrder = %v, want 1", got)
+		}
+	})
+	t.Run("no sequence", func(t *testing.T) {
+		if got := (Question{}).GradeOrder([]string{"a"}); got != 0 {
+			t.Errorf("GradeOrder = %v, want 0", got)
+		}
+	})
+}
+
+func TestCheckBlank(t *testing.T) {
+	q := Question{Type: typeCloze, Blanks: []Blank{
+		{Answer: "Roma"},
+		{Answer: "Cesare", Alternatives: []string{"Giulio Cesare"}},
+	}}
+	tests := []struct {
+		name     string
+		i        int
+		response string
+		want     bool
+	}{
+		{"exact", 0, "Roma", true},
+		{"case and spaces", 0, "  roma ", true},
+		{"punctuation", 0, "Roma.", true},
+		{"alternative", 1, "giulio  cesare", true},
+		{"wrong", 0, "Milano", false},
+		{"other gap", 0, "Cesare", false},
+		{"empty", 0, " ", false},
+		{"accents count", 0, "Romà", false},
+		{"out of range", 2, "Roma", false},
+		{"negative", -1, "Roma", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := q.CheckBlank(tt.i, tt.response); got != tt.want {
+				t.Errorf("CheckBlank(%d, %q) = %v, want %v", tt.i, tt.response, got, tt.want)
+			}
+		})
+	}
+
+	if got := q.GradeBlanks([]string{"roma", "Pompeo"}); got != 0.5 {
+		t.Errorf("GradeBlanks = %v, want 0.5", got)
+	}
+}
+
+func TestCheckPair(t *testing.T) {
+	q := Question{Type: typeMatching, Pairs: []Pair{
+		{Left: "Roma", Right: "Lazio"},
+		{Left: "Milano", Right: "Lombardia"},
+	}}
+	tests := []struct {
+		name  string
+		i     int
+		right string
+		want  bool
+	}{
+		{"right", 0, "Lazio", true},
+		{"case and spaces", 1, " lombardia ", true},
+		{"swapped", 0, "Lombardia", false},
+		{"empty", 0, "", false},
+		{"out of range", 2, "Lazio", false},
+		{"negative", -1, "Lazio", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := q.CheckPair(tt.i, tt.right); got != tt.want {
+				t.Errorf("CheckPair(%d, %q) = %v, want %v", tt.i, tt.right, got, tt.want)
+			}
+		})
+	}
+
+	if got := q.GradeMatching([]string{"Lombardia", "Lombardia"}); got != 0.5 {
+		t.Errorf("GradeMatching = %v, want 0.5", got)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// quizCheck grades what the student entered for one question. score is
+// between 0 and 1; graded is false for questions the app can't check, whose
+// answer is only shown.
+type quizCheck func() (score float64, graded bool, feedback string)
+
+// quizInput builds the answer widgets for q and the function that checks
+// them.
+func quizInput(q Question) (fyne.CanvasObject, quizCheck) {
+	switch {
+	case len(q.Choices) > 0:
+		labels := make([]string, len(q.Choices))
+		for i, c := range q.Choices {
+			labels[i] = choiceLabel(i) + " " + c.Text
+		}
+		radio := widget.NewRadioGroup(labels, nil)
+		return radio, func() (float64, bool, string) {
+			picked := -1
+			for i, l := range labels {
+				if l == radio.Selected {
+					picked = i
+				}
+			}
+			k, right := q.CorrectChoice(), q.GradeChoice(picked)
+			if k < 0 {
+				// No choice marked correct, as in a hand-edited file
+				return 0, false, "Risposta attesa: " + q.Answer
+			}
+			var b strings.Builder
+			if right {
+				b.WriteString("Corretto!")
+			} else {
+				fmt.Fprintf(&b, "Sbagliato: la risposta è %s", labels[k])
+			}
+			if picked >= 0 && picked != k && q.Choices[picked].Rationale != "" {
+				fmt.Fprintf(&b, "\n%s %s", choiceLabel(picked), q.Choices[picked].Rationale)
+			}
+			if q.Choices[k].Rationale != "" {
+				fmt.Fprintf(&b, "\n%s %s", choiceLabel(k), q.Choices[k].Rationale)
+			}
+			if right {
+				return 1, true, b.String()
+			}
+			return 0, true, b.String()
+		}
+
+	case len(q.Pairs) > 0:
+		rights := make([]string, len(q.Pairs))
+		for i, k := range shuffledIndexes(len(q.Pairs)) {
+			rights[i] = q.Pairs[k].Right
+		}
+		selects := make([]*widget.Select, len(q.Pairs))
+		form := widget.NewForm()
+		for i, p := range q.Pairs {
+			selects[i] = widget.NewSelect(rights, nil)
+			form.Append(p.Left, selects[i])
+		}
+		return form, func() (float64, bool, string) {
+			picked := make([]string, len(selects))
+			var lines []string
+			for i, s := range selects {
+				picked[i] = s.Selected
+				mark := "✗"
+				if q.CheckPair(i, s.Selected) {
+					mark = "✓"
+				}
+				lines = append(lines, fmt.Sprintf("%s %s → %s", mark, q.Pairs[i].Left, q.Pairs[i].Right))
+			}
+			return q.GradeMatching(picked), true, strings.Join(lines, "\n")
+		}
+
+	case len(q.Sequence) > 0:
+		order := make([]string, len(q.Sequence))
+		for i, k := range shuffledIndexes(len(q.Sequence)) {
+			order[i] = q.Sequence[k]
+		}
+		list := container.NewVBox()
+		var fill func()
+		fill = func() {
+			list.Objects = nil
+			for i, item := range order {
+				up := widget.NewButtonWithIcon("", theme.MoveUpIcon(), func() {
+					order[i-1], order[i] = order[i], order[i-1]
+					fill()
+				})
+				down := widget.NewButtonWithIcon("", theme.MoveDownIcon(), func() {
+					order[i], order[i+1] = order[i+1], order[i]
+					fill()
+				})
+				if i == 0 {
+					up.Disable()
+				}
+				if i == len(order)-1 {
+					down.Disable()
+				}
+				label := widget.NewLabel(item)
+				label.Wrapping = fyne.TextWrapWord
+				list.Add(container.NewBorder(nil, nil, widget.NewLabel(fmt.Sprintf("%d.", i+1)), container.NewHBox(up, down), label))
+			}
+			list.Refresh()
+		}
+		fill()
+		return list, func() (float64, bool, string) {
+			score := q.GradeOrder(order)
+			if score == 1 {
+				return score, true, "Corretto! " + q.Explanation
+			}
+			var lines []string
+			for i, item := range q.Sequence {
+				lines = append(lines, fmt.Sprintf("%d. %s", i+1, item))
+			}
+			return score, true, fmt.Sprintf("Ordine giusto al %.0f%%. L'ordine corretto è:\n%s\n%s", score*100, strings.Join(lines, "\n"), q.Explanation)
+		}
+
+	case len(q.Blanks) > 0:
+		entries := make([]*widget.Entry, len(q.Blanks))
+		form := container.NewVBox()
+		for i := range q.Blanks {
+			entries[i] = widget.NewEntry()
+			if len(q.Blanks) > 1 {
+				form.Add(container.NewBorder(nil, nil, widget.NewLabel(fmt.Sprintf("(%d)", i+1)), nil, entries[i]))
+			} else {
+				form.Add(entries[i])
+			}
+		}
+		return form, func() (float64, bool, string) {
+			responses := make([]string, len(entries))
+			var lines []string
+			for i, e := range entries {
+				responses[i] = e.Text
+				mark := "✗"
+				if q.CheckBlank(i, e.Text) {
+					mark = "✓"
+				}
+				line := fmt.Sprintf("%s %s", mark, q.Blanks[i].Answer)
+				if len(q.Blanks) > 1 {
+					line = fmt.Sprintf("%s (%d) %s", mark, i+1, q.Blanks[i].Answer)
+				}
+				if alts := q.Blanks[i].Alternatives; len(alts) > 0 {
+					line += " (anche: " + strings.Join(alts, ", ") + ")"
+				}
+				lines = append(lines, line)
+			}
+			return q.GradeBlanks(responses), true, strings.Join(lines, "\n")
+		}
+
+	case q.Numeric != nil:
+		entry := widget.NewEntry()
+		switch q.Numeric.Kind {
+		case numericDate:
+			entry.SetPlaceHolder("Data, per esempio 14/07/1789")
+		case numericPercent:
+			entry.SetPlaceHolder("Percentuale")
+		case numericYear:
+			entry.SetPlaceHolder("Anno")
+		default:
+			entry.SetPlaceHolder("Numero")
+			if q.Numeric.Unit != "" {
+				entry.SetPlaceHolder("Numero, in " + q.Numeric.Unit)
+			}
+		}
+		return entry, func() (float64, bool, string) {
+			want := q.Numeric.String()
+			if tol := q.Numeric.ToleranceText(); tol != "" {
+				want += " (" + tol + ")"
+			}
+			ok, understood := q.CheckNumber(entry.Text)
+			switch {
+			case ok:
+				return 1, true, "Corretto! La risposta è " + want + ". " + q.Explanation
+			case !understood && strings.TrimSpace(entry.Text) != "":
+				return 0, true, "Risposta non riconosciuta come valore: la risposta è " + want + ". " + q.Explanation
+			}
+			return 0, true, "Sbagliato: la risposta è " + want + ". " + q.Explanation
+		}
+
+	case q.Type == typeTrueFalse:
+		if key, ok := q.trueFalseKey(); ok {
+			radio := widget.NewRadioGroup([]string{"Vero", "Falso"}, nil)
+			radio.Horizontal = true
+			return radio, func() (float64, bool, string) {
+				if radio.Selected != "" && (radio.Selected == "Vero") == key {
+					return 1, true, "Corretto! " + q.Explanation
+				}
+				return 0, true, "Sbagliato: è " + q.Answer + ". " + q.Explanation
+			}
+		}
+	}
+
+	if q.Type == typeEssay {
+		entry := widget.NewMultiLineEntry()
+		entry.SetPlaceHolder("Scrivi il tuo tema, poi valutalo con la griglia")
+		entry.Wrapping = fyne.TextWrapWord
+		entry.SetMinRowsVisible(8)
+		return entry, func() (float64, bool, string) {
+			return 0, false, "Schema di risposta: " + q.Answer + "\n\n" + q.essayGuide("")
+		}
+	}
+
+	// Anything else is answered freely and compared by the student
+	entry := widget.NewMultiLineEntry()
+	entry.SetPlaceHolder("Scrivi la tua risposta, poi confrontala con quella attesa")
+	entry.Wrapping = fyne.TextWrapWord
+	return entry, func() (float64, bool, string) {
+		return 0, false, "Risposta attesa: " + q.Answer
+	}
+}
+
+// shuffledIndexes returns 0..n-1 in random order, never the original one.
+func shuffledIndexes(n int) []int {
+	order := rand.Perm(n)
+	if slices.IsSorted(order) && n > 1 {
+		order = append(order[1:], order[0])
+	}
+	return order
+}
+
+// showQuiz walks the student through qs one question at a time and checks
+// the answers of the questions that can be graded automatically.
+func showQuiz(w fyne.Window, qs *QuestionSet) {
+	if qs == nil || len(qs.Questions) == 0 {
+		return
+	}
+	var score float64
+	graded := 0
+
+	progress := widget.NewLabel("")
+	stem := widget.NewLabel("")
+	stem.Wrapping = fyne.TextWrapWord
+	stem.TextStyle = fyne.TextStyle{Bold: true}
+	feedback := widget.NewLabel("")
+	feedback.Wrapping = fyne.TextWrapWord
+	inputBox := container.NewStack()
+	checkBtn := widget.NewButton("Verifica", nil)
+	checkBtn.Importance = widget.HighImportance
+	nextBtn := widget.NewButton("Avanti", nil)
+
+	// Feedback scrolls with the answer, since a rubric can be long
+	content := container.NewBorder(
+		container.NewVBox(progress, stem),
+		container.NewHBox(checkBtn, nextBtn),
+		nil, nil,
+		container.NewVScroll(container.NewVBox(inputBox, feedback)),
+	)
+	d := dialog.NewCustom("Quiz", "Chiudi", content, w)
+
+	var show func(i int)
+	show = func(i int) {
+		if i == len(qs.Questions) {
+			text := fmt.Sprintf("Punteggio: %.1f su %d domande valutate.", score, graded)
+			if open := len(qs.Questions) - graded; open > 0 {
+				text += fmt.Sprintf("\n%d domande a risposta libera sono da valutare confrontando la risposta attesa.", open)
+			}
+			progress.SetText("Quiz completato")
+			stem.SetText(text)
+			inputBox.Objects = nil
+			inputBox.Refresh()
+			feedback.SetText("")
+			checkBtn.Hide()
+			nextBtn.Hide()
+			return
+		}
+		q := qs.Questions[i]
+		progress.SetText(fmt.Sprintf("Domanda %d di %d", i+1, len(qs.Questions)))
+		stem.SetText(q.StemText())
+		input, check := quizInput(q)
+		inputBox.Objects = []fyne.CanvasObject{input}
+		inputBox.Refresh()
+		feedback.SetText("")
+		checkBtn.Enable()
+		nextBtn.Disable()
+		if i == len(qs.Questions)-1 {
+			nextBtn.SetText("Risultato")
+		}
+		checkBtn.OnTapped = func() {
+			s, ok, text := check()
+			if ok {
+				score += s
+				graded++
+			}
+			feedback.SetText(strings.TrimSpace(text))
+			checkBtn.Disable()
+			nextBtn.Enable()
+		}
+		nextBtn.OnTapped = func() { show(i + 1) }
+	}
+	show(0)
+	d.Resize(fyne.NewSize(640, 520))
+	d.Show()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAttempts = 3
+	maxAttempts     = 10
+	retryBaseDelay  = 2 * time.Second
+	retryMaxDelay   = 30 * time.Second
+	// A server asking us to wait longer than this is treated as a hard
+	// failure: nobody wants the UI to sit there for ten minutes
+	maxRetryAfter = 2 * time.Minute
+)
+
+// errStalled marks a streamed reply that stopped arriving.
+var errStalled = errors.New("stream stalled")
+
+// retryingProvider retries failed requests that are likely to succeed a
+// little later: rate limits, overloaded or unreachable servers, and network
+// errors. A streamed reply that already delivered text is never retried,
+// since the caller has seen it.
+type retryingProvider struct {
+	Provider
+	attempts int
+}
+
+// retryError is returned when every attempt failed.
+type retryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *retryError) Error() string {
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *retryError) Unwrap() error { return e.Err }
+
+func (p *retryingProvider) Complete(ctx context.Context, req completionRequest) (completionResponse, error) {
+	onDelta := req.OnDelta
+	delivered := false
+	if onDelta != nil {
+		req.OnDelta = func(text string) {
+			delivered = true
+			onDelta(text)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := p.Provider.Complete(ctx, req)
+		if err == nil || delivered || ctx.Err() != nil || !retryable(err) {
+			return resp, err
+		}
+		if attempt >= p.attempts {
+			if attempt == 1 {
+				return resp, err
+			}
+			return resp, &retryError{Attempts: attempt, Err: err}
+		}
+
+		wait := backoff(attempt)
+		var he *httpError
+		if errors.As(err, &he) && he.RetryAfter > 0 {
+			if he.RetryAfter > maxRetryAfter {
+				return resp, err
+			}
+			wait = he.RetryAfter
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return resp, ctx.Err()
+		}
+	}
+}
+
+// retryable reports whether err is worth another attempt.
+func retryable(err error) bool {
+	var he *httpError
+	if errors.As(err, &he) {
+		switch he.Status {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+			529: // Anthropic: overloaded
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, errStalled) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// backoff is the jittered exponential delay before attempt+1.
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << (attempt - 1)
+	if d > retryMaxDelay || d <= 0 {
+		d = retryMaxDelay
+	}
+	// Full jitter in [d/2, d), so parallel chunks don't retry in lockstep
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// parseAttempts reads the number of attempts per request; empty means the default.
+func parseAttempts(text string) (int, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil || n < 1 || n > maxAttempts {
+		return 0, fmt.Errorf("invalid number of attempts %q, expected 1-%d", text, maxAttempts)
+	}
+	return n, nil
+}
+
+// userMessage explains a generation error in terms the user can act on,
+// keeping the technical details on a second line.
+func userMessage(err error) string {
+	var msg string
+	var he *httpError
+	var ne net.Error
+	var be *budgetError
+	var up *unpricedError
+	switch {
+	case errors.As(err, &be):
+		if be.Period == periodDay {
+			return fmt.Sprintf("Budget giornaliero di %s raggiunto: oggi sono già stati spesi %s e questa generazione costerebbe circa %s. Riprova domani o modifica il limite nelle impostazioni.", formatCost(be.Limit), formatCost(be.Spent), formatCost(be.Estimate))
+		}
+		return fmt.Sprintf("Budget mensile di %s raggiunto: questo mese sono già stati spesi %s e questa generazione costerebbe circa %s. Modifica il limite nelle impostazioni se necessario.", formatCost(be.Limit), formatCost(be.Spent), formatCost(be.Estimate))
+	case errors.As(err, &up):
+		return fmt.Sprintf("Il prezzo di %s non è nel listino: i limiti di spesa non possono essere verificati per questa generazione. Aggiorna il listino prezzi nelle impostazioni o scegli un modello con prezzo noto.", up.Model)
+	case errors.As(err, &he):
+		switch {
+		case he.Status == http.StatusUnauthorized || he.Status == http.StatusForbidden:
+			msg = fmt.Sprintf("%s ha rifiutato la chiave API (HTTP %d): controlla la chiave nelle impostazioni e che abbia accesso al modello scelto.", he.Provider, he.Status)
+		case he.Status == http.StatusPaymentRequired:
+			msg = fmt.Sprintf("Credito esaurito su %s (HTTP 402): ricarica il credito o scegli un modello più economico.", he.Provider)
+		case he.Status == http.StatusTooManyRequests:
+			msg = fmt.Sprintf("%s sta limitando le richieste (HTTP 429): attendi qualche minuto e riprova, oppure usa un altro modello.", he.Provider)
+		case he.Status == http.StatusNotFound:
+			msg = fmt.Sprintf("%s non trova il modello o l'endpoint (HTTP 404): controlla il nome del modello e l'endpoint.", he.Provider)
+		case he.Status >= 500:
+			msg = fmt.Sprintf("%s o il modello scelto non è al momento disponibile (HTTP %d): riprova più tardi o scegli un altro modello.", he.Provider, he.Status)
+		}
+	case errors.Is(err, errStalled):
+		msg = "Il server ha smesso di inviare dati: riprova, oppure aumenta il timeout nelle impostazioni di rete."
+	case errors.As(err, &ne):
+		msg = "Impossibile raggiungere il server: controlla la connessione e le impostazioni di rete (proxy, certificati)."
+	}
+	if msg == "" {
+		return err.Error()
+	}
+	var re *retryError
+	if errors.As(err, &re) {
+		msg += fmt.Sprintf(" Tentativi effettuati: %d.", re.Attempts)
+	}
+	return msg + "\n\nDettagli: " + err.Error()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", &httpError{Status: http.StatusTooManyRequests}, true},
+		{"bad gateway", &httpError{Status: http.StatusBadGateway}, true},
+		{"unavailable", &httpError{Status: http.StatusServiceUnavailable}, true},
+		{"anthropic overloaded", &httpError{Status: 529}, true},
+		{"wrapped", fmt.Errorf("parte 2/3: %w", &httpError{Status: http.StatusGatewayTimeout}), true},
+		{"bad key", &httpError{Status: http.StatusUnauthorized}, false},
+		{"no credit", &httpError{Status: http.StatusPaymentRequired}, false},
+		{"bad request", &httpError{Status: http.StatusBadRequest}, false},
+		{"stalled stream", fmt.Errorf("reading: %w", errStalled), true},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"cancelled", context.Canceled, false},
+		{"bad reply", errors.New("no questions generated"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        string
+		min, max time.Duration
+	}{
+		{"empty", "", 0, 0},
+		{"seconds", "30", 30 * time.Second, 30 * time.Second},
+		{"padded", " 5 ", 5 * time.Second, 5 * time.Second},
+		{"zero", "0", 0, 0},
+		{"negative", "-3", 0, 0},
+		{"garbage", "presto", 0, 0},
+		{"date ahead", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{"date past", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.v); got < tt.min || got > tt.max {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v-%v", tt.v, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration // the delay before jitter
+	}{
+		{1, retryBaseDelay},
+		{2, 2 * retryBaseDelay},
+		{3, 4 * retryBaseDelay},
+		{10, retryMaxDelay},
+		{70, retryMaxDelay}, // the shift overflows
+	}
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			if got := backoff(tt.attempt); got < tt.base/2 || got >= tt.base {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v)", tt.attempt, got, tt.base/2, tt.base)
+			}
+		}
+	}
+}
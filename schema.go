@@ -0,0 +1,834 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Question types as they appear in the model's JSON output
+const (
+	typeOpen           = "open"
+	typeTrueFalse      = "true_false"
+	typeSequence       = "sequence"
+	typeNumeric        = "numeric"
+	typeMultipleChoice = "multiple_choice"
+	typeCloze          = "cloze"
+	typeMatching       = "matching"
+	typeEssay          = "essay"
+)
+
+// jsonSchema is a named JSON schema for structured output.
+type jsonSchema struct {
+	Name   string
+	Schema map[string]interface{}
+}
+
+// generatedQuestion is one question exactly as the model returns it.
+type generatedQuestion struct {
+	ID          flexString        `json:"id"`
+	Type        string            `json:"type"`
+	Text        string            `json:"text"`
+	Options     []string          `json:"options"`
+	Choices     []generatedChoice `json:"choices"`
+	Blanks      []generatedBlank  `json:"blanks"`
+	Pairs       []generatedPair   `json:"pairs"`
+	Sequence    []string          `json:"sequence"`
+	Numeric     *generatedNumeric `json:"numeric"`
+	KeyPoints   []string          `json:"key_points"`
+	Rubric      []generatedRubric `json:"rubric"`
+	Answer      flexString        `json:"answer"`
+	Explanation string            `json:"explanation"`
+	Difficulty  string            `json:"difficulty"`
+	Tags        []string          `json:"tags"`
+	SourceFile  string            `json:"source_file"`
+	SourcePage  flexString        `json:"source_page"`
+	SourceQuote string            `json:"source_quote"`
+}
+
+type generatedChoice struct {
+	Text      string   `json:"text"`
+	Correct   flexBool `json:"correct"`
+	Rationale string   `json:"rationale"`
+}
+
+type generatedBlank struct {
+	Answer       flexString `json:"answer"`
+	Alternatives []string   `json:"alternatives"`
+}
+
+type generatedPair struct {
+	Left  flexString `json:"left"`
+	Right flexString `json:"right"`
+}
+
+type generatedNumeric struct {
+	Kind      string     `json:"kind"`
+	Value     flexString `json:"value"`
+	Date      string     `json:"date"`
+	Unit      string     `json:"unit"`
+	Tolerance flexString `json:"tolerance"`
+}
+
+type generatedRubric struct {
+	Criterion string `json:"criterion"`
+	Levels    []struct {
+		Points      flexString `json:"points"`
+		Description string     `json:"description"`
+	} `json:"levels"`
+}
+
+type generatedSet struct {
+	Questions []generatedQuestion `json:"questions"`
+}
+
+// flexString accepts strings, numbers and booleans, since models are not
+// consistent about quoting answers like true or 1789.
+type flexString string
+
+func (f *flexString) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*f = flexString(s)
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case nil:
+		*f = ""
+	case bool:
+		if t {
+			*f = "Vero"
+		} else {
+			*f = "Falso"
+		}
+	case float64:
+		*f = flexString(strconv.FormatFloat(t, 'f', -1, 64))
+	default:
+		// Arrays and objects: keep the raw JSON rather than failing the whole set
+		*f = flexString(string(data))
+	}
+	return nil
+}
+
+// flexBool accepts booleans as well as "true", "vero", "sì" and 1.
+type flexBool bool
+
+func (f *flexBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case bool:
+		*f = flexBool(t)
+	case float64:
+		*f = t == 1
+	case string:
+		switch strings.ToLower(strings.TrimSpace(t)) {
+		case "true", "vero", "sì", "si", "yes", "1":
+			*f = true
+		default:
+			*f = false
+		}
+	default:
+		*f = false
+	}
+	return nil
+}
+
+// questionsSchema describes generatedSet. Every property is required and
+// additionalProperties is false so that strict mode providers accept it.
+func questionsSchema() *jsonSchema {
+	str := map[string]interface{}{"type": "string"}
+	choice := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"text":      str,
+			"correct":   map[string]interface{}{"type": "boolean"},
+			"rationale": str,
+		},
+		"required":             []string{"text", "correct", "rationale"},
+		"additionalProperties": false,
+	}
+	blank := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"answer":       str,
+			"alternatives": map[string]interface{}{"type": "array", "items": str},
+		},
+		"required":             []string{"answer", "alternatives"},
+		"additionalProperties": false,
+	}
+	numeric := map[string]interface{}{
+		"anyOf": []interface{}{
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"kind":      map[string]interface{}{"type": "string", "enum": numericKinds},
+					"value":     map[string]interface{}{"type": "number"},
+					"date":      str,
+					"unit":      str,
+					"tolerance": map[string]interface{}{"type": "number"},
+				},
+				"required":             []string{"kind", "value", "date", "unit", "tolerance"},
+				"additionalProperties": false,
+			},
+			map[string]interface{}{"type": "null"},
+		},
+	}
+	criterion := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"criterion": str,
+			"levels": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type":                 "object",
+					"properties":           map[string]interface{}{"points": map[string]interface{}{"type": "number"}, "description": str},
+					"required":             []string{"points", "description"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"criterion", "levels"},
+		"additionalProperties": false,
+	}
+	pair := map[string]interface{}{
+		"type":                 "object",
+		"properties":           map[string]interface{}{"left": str, "right": str},
+		"required":             []string{"left", "right"},
+		"additionalProperties": false,
+	}
+	return &jsonSchema{
+		Name: "question_set",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"questions": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"id":           map[string]interface{}{"type": "integer"},
+							"type":         map[string]interface{}{"type": "string", "enum": []string{typeOpen, typeTrueFalse, typeSequence, typeNumeric, typeMultipleChoice, typeCloze, typeMatching, typeEssay}},
+							"text":         str,
+							"options":      map[string]interface{}{"type": "array", "items": str},
+							"choices":      map[string]interface{}{"type": "array", "items": choice},
+							"blanks":       map[string]interface{}{"type": "array", "items": blank},
+							"pairs":        map[string]interface{}{"type": "array", "items": pair},
+							"sequence":     map[string]interface{}{"type": "array", "items": str},
+							"numeric":      numeric,
+							"key_points":   map[string]interface{}{"type": "array", "items": str},
+							"rubric":       map[string]interface{}{"type": "array", "items": criterion},
+							"answer":       str,
+							"explanation":  str,
+							"difficulty":   map[string]interface{}{"type": "string", "enum": []string{difficultyEasy, difficultyMedium, difficultyHard}},
+							"tags":         map[string]interface{}{"type": "array", "items": str},
+							"source_file":  str,
+							"source_page":  map[string]interface{}{"type": "integer"},
+							"source_quote": str,
+						},
+						"required":             []string{"id", "type", "text", "options", "choices", "blanks", "pairs", "sequence", "numeric", "key_points", "rubric", "answer", "explanation", "difficulty", "tags", "source_file", "source_page", "source_quote"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"questions"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+// decodeQuestions parses the model output, repairing the usual drift
+// (code fences, prose around the JSON, trailing commas, truncation).
+func decodeQuestions(raw string) (generatedSet, error) {
+	cleaned := cleanJSON(raw)
+	candidates := []string{strings.TrimSpace(raw), cleaned}
+	if st := scanJSON(cleaned); len(st.stack) > 0 || st.inString {
+		// Truncated reply: prefer dropping the half-written question over
+		// keeping it with a cut-off text or answer
+		if st.lastElem > 0 {
+			candidates = append(candidates, closeJSON(cleaned[:st.lastElem], st.lastElemStack, false))
+		}
+		candidates = append(candidates, closeJSON(cleaned, st.stack, st.inString))
+	}
+
+	var lastErr error
+	for _, c := range candidates {
+		set, err := unmarshalSet(c)
+		if err == nil && len(set.Questions) > 0 {
+			return set, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("no questions found")
+		}
+		lastErr = err
+	}
+	return generatedSet{}, fmt.Errorf("model did not return valid JSON: %v\nRaw: %s", lastErr, truncate(raw, 800))
+}
+
+// partialQuestions returns the questions already complete in a reply that
+// is still streaming in.
+func partialQuestions(raw string) []Question {
+	cleaned := cleanJSON(raw)
+	st := scanJSON(cleaned)
+	if st.lastElem == 0 {
+		return nil
+	}
+	set, err := unmarshalSet(closeJSON(cleaned[:st.lastElem], st.lastElemStack, false))
+	if err != nil {
+		return nil
+	}
+	return set.toQuestionSet().Questions
+}
+
+// unmarshalSet accepts either {"questions": [...]} or a bare array.
+func unmarshalSet(s string) (generatedSet, error) {
+	var set generatedSet
+	if strings.HasPrefix(s, "[") {
+		err := json.Unmarshal([]byte(s), &set.Questions)
+		return set, err
+	}
+	err := json.Unmarshal([]byte(s), &set)
+	return set, err
+}
+
+// cleanJSON extracts the JSON value from raw, dropping surrounding prose and
+// code fences, and fixes typographic quotes, raw newlines inside strings and
+// trailing commas. Brackets left open by a truncated reply stay open.
+func cleanJSON(raw string) string {
+	s := strings.TrimSpace(raw)
+	if i := strings.Index(s, "```"); i >= 0 {
+		s = s[i+3:]
+		s = strings.TrimPrefix(s, "json")
+		if j := strings.LastIndex(s, "```"); j >= 0 {
+			s = s[:j]
+		}
+	}
+	start := strings.IndexAny(s, "{[")
+	if start < 0 {
+		return strings.TrimSpace(s)
+	}
+	s = strings.NewReplacer("“", `"`, "”", `"`).Replace(s[start:])
+
+	var out strings.Builder
+	depth := 0
+	inString, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			case c == '\n':
+				// Raw newlines are invalid inside JSON strings
+				out.WriteString(`\n`)
+				continue
+			}
+			out.WriteByte(c)
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			dropTrailingComma(&out)
+			depth--
+		}
+		out.WriteByte(c)
+		if depth == 0 && (c == '}' || c == ']') {
+			break // ignore anything after the value
+		}
+	}
+	return out.String()
+}
+
+func dropTrailingComma(b *strings.Builder) {
+	str := strings.TrimRight(b.String(), " \t\r\n")
+	if strings.HasSuffix(str, ",") {
+		b.Reset()
+		b.WriteString(str[:len(str)-1])
+	}
+}
+
+type jsonScan struct {
+	stack    []byte // closers still needed, innermost last
+	inString bool
+	// End offset of the last object closed directly inside an array, and
+	// the closers needed at that point
+	lastElem      int
+	lastElemStack []byte
+}
+
+func scanJSON(s string) jsonScan {
+	var st jsonScan
+	escaped := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if st.inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				st.inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			st.inString = true
+		case '{':
+			st.stack = append(st.stack, '}')
+		case '[':
+			st.stack = append(st.stack, ']')
+		case '}', ']':
+			if len(st.stack) > 0 {
+				st.stack = st.stack[:len(st.stack)-1]
+			}
+			if c == '}' && len(st.stack) > 0 && st.stack[len(st.stack)-1] == ']' {
+				st.lastElem = i + 1
+				st.lastElemStack = append([]byte(nil), st.stack...)
+			}
+		}
+	}
+	return st
+}
+
+// closeJSON terminates an open string and appends the missing closers.
+func closeJSON(s string, stack []byte, inString bool) string {
+	if inString {
+		s += `"`
+	}
+	s = strings.TrimRight(s, " \t\r\n")
+	s = strings.TrimSuffix(s, ",")
+	// A dangling key without value can't be closed meaningfully
+	if strings.HasSuffix(s, ":") {
+		s += `""`
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		s += string(stack[i])
+	}
+	return s
+}
+
+// completeStructured asks for schema-constrained output and, if the provider
+// rejects response_format outright, retries once relying on the prompt alone.
+func completeStructured(ctx context.Context, p Provider, req completionRequest) (completionResponse, error) {
+	resp, err := p.Complete(ctx, req)
+	var he *httpError
+	if err != nil && req.Schema != nil && errors.As(err, &he) && he.Status == http.StatusBadRequest {
+		req.Schema = nil
+		return p.Complete(ctx, req)
+	}
+	return resp, err
+}
+
+// toQuestionSet converts the wire format into the app's model. Each
+// question is converted by its declared type, falling back to an open
+// question when the fields of that type are missing or unusable.
+func (gs generatedSet) toQuestionSet() *QuestionSet {
+	qs := &QuestionSet{CreatedAt: time.Now()}
+	for _, gq := range gs.Questions {
+		if strings.TrimSpace(gq.Text) == "" {
+			continue
+		}
+		qType := gq.Type
+		if qType == "" {
+			qType = inferredType(gq)
+		}
+		q := Question{
+			Type:        qType,
+			Stem:        strings.TrimSpace(gq.Text),
+			Options:     gq.Options,
+			Answer:      strings.TrimSpace(string(gq.Answer)),
+			Explanation: strings.TrimSpace(gq.Explanation),
+			Difficulty:  gq.Difficulty,
+			Tags:        gq.Tags,
+			Source: Source{
+				File:  strings.TrimSpace(gq.SourceFile),
+				Page:  pageNumber(gq.SourcePage),
+				Quote: strings.TrimSpace(gq.SourceQuote),
+			},
+		}
+		// Exactly one conversion applies: fields of other types that the
+		// model filled in anyway are ignored
+		switch qType {
+		case typeMultipleChoice:
+			q.Choices = multipleChoices(gq)
+			q.Options = nil
+			if k := q.CorrectChoice(); k >= 0 {
+				q.Type = typeMultipleChoice
+				q.Answer = q.Choices[k].Text
+			} else {
+				// Without a key it can't be graded: keep it as an open
+				// question listing the options
+				q.Type = typeOpen
+				q.Options = choiceTexts(q.Choices)
+				q.Choices = nil
+			}
+		case typeCloze:
+			if stem, blanks := clozeBlanks(q.Stem, gq.Blanks); len(blanks) > 0 {
+				q.Type = typeCloze
+				q.Stem = stem
+				q.Blanks = blanks
+				q.Answer = strings.Join(blankAnswers(blanks), "; ")
+			} else {
+				q.Type = typeOpen
+				if q.Answer == "" {
+					var answers []string
+					for _, gb := range gq.Blanks {
+						answers = append(answers, string(gb.Answer))
+					}
+					q.Answer = strings.Join(answers, "; ")
+				}
+			}
+		case typeMatching:
+			if pairs := matchingPairs(gq.Pairs); len(pairs) >= 2 {
+				q.Type = typeMatching
+				q.Pairs = pairs
+				q.Options = nil
+				var matches []string
+				for _, p := range pairs {
+					matches = append(matches, p.Left+" → "+p.Right)
+				}
+				q.Answer = strings.Join(matches, "; ")
+			} else {
+				q.Type = typeOpen
+			}
+		case typeSequence:
+			if seq := sequenceItems(gq); len(seq) >= 2 {
+				q.Type = typeSequence
+				q.Sequence = seq
+				q.Options = nil
+				q.Answer = strings.Join(seq, " → ")
+			} else {
+				q.Type = typeOpen
+			}
+		case typeNumeric:
+			if n := numericAnswer(gq.Numeric, q.Answer); n != nil {
+				q.Type = typeNumeric
+				q.Numeric = n
+				if q.Answer == "" {
+					q.Answer = n.String()
+				}
+			} else {
+				q.Type = typeOpen
+			}
+		case typeEssay:
+			for _, kp := range gq.KeyPoints {
+				if kp = strings.TrimSpace(kp); kp != "" {
+					q.KeyPoints = append(q.KeyPoints, kp)
+				}
+			}
+			q.Rubric = rubricCriteria(gq.Rubric)
+			if len(q.KeyPoints) > 0 || len(q.Rubric) > 0 {
+				q.Type = typeEssay
+			} else {
+				q.Type = typeOpen
+			}
+		}
+		qs.Questions = append(qs.Questions, q)
+	}
+	qs.Renumber()
+	return qs
+}
+
+// inferredType is the type of a question whose reply doesn't declare one,
+// from the fields the model filled in.
+func inferredType(gq generatedQuestion) string {
+	switch {
+	case len(gq.Choices) > 0:
+		return typeMultipleChoice
+	case len(gq.Blanks) > 0:
+		return typeCloze
+	case len(gq.Pairs) > 0:
+		return typeMatching
+	case len(gq.Sequence) > 0:
+		return typeSequence
+	case gq.Numeric != nil:
+		return typeNumeric
+	case len(gq.Rubric) > 0:
+		return typeEssay
+	}
+	return typeOpen
+}
+
+// multipleChoices reads the choices of a multiple choice question, keeping
+// exactly one marked correct when possible. Models that ignore "choices"
+// and list the options as plain strings are matched by the answer text, or
+// by a letter answer such as "b" or "b) ...".
+func multipleChoices(gq generatedQuestion) []Choice {
+	var choices []Choice
+	for _, gc := range gq.Choices {
+		if text := strings.TrimSpace(gc.Text); text != "" {
+			choices = append(choices, Choice{Text: text, Correct: bool(gc.Correct), Rationale: strings.TrimSpace(gc.Rationale)})
+		}
+	}
+	if len(choices) == 0 {
+		for _, opt := range gq.Options {
+			if text := strings.TrimSpace(opt); text != "" {
+				choices = append(choices, Choice{Text: text})
+			}
+		}
+	}
+
+	correct := 0
+	for i := range choices {
+		if choices[i].Correct {
+			correct++
+			// A second key would make the question ambiguous
+			choices[i].Correct = correct == 1
+		}
+	}
+	if correct == 0 {
+		if k := matchChoice(choices, string(gq.Answer)); k >= 0 {
+			choices[k].Correct = true
+		}
+	}
+	return choices
+}
+
+// matchChoice finds the choice an answer refers to, by text or by letter.
+func matchChoice(choices []Choice, answer string) int {
+	answer = strings.TrimSpace(answer)
+	for i, c := range choices {
+		if strings.EqualFold(c.Text, answer) {
+			return i
+		}
+	}
+	lower := strings.ToLower(answer)
+	for i := range choices {
+		letter := choiceLabel(i)[:1]
+		if lower == letter || strings.HasPrefix(lower, choiceLabel(i)) || strings.HasPrefix(lower, letter+".") {
+			return i
+		}
+	}
+	return -1
+}
+
+func choiceTexts(choices []Choice) []string {
+	texts := make([]string, len(choices))
+	for i, c := range choices {
+		texts[i] = c.Text
+	}
+	return texts
+}
+
+// Gaps as models write them in a cloze stem: a run of underscores or, when
+// there is none, of dots as in "la capitale è ...".
+var (
+	gapPattern    = regexp.MustCompile(`_{3,}`)
+	dotGapPattern = regexp.MustCompile(`\.{3,}|…`)
+)
+
+// clozeBlanks normalizes the gaps of a cloze stem to blankMarker. A model
+// that left the terms in place gets them blanked here. It returns no blanks
+// when gaps and answers don't match up, so the question can't be checked.
+func clozeBlanks(stem string, gbs []generatedBlank) (string, []Blank) {
+	var blanks []Blank
+	for _, gb := range gbs {
+		if answer := strings.TrimSpace(string(gb.Answer)); answer != "" {
+			var alts []string
+			for _, a := range gb.Alternatives {
+				if a = strings.TrimSpace(a); a != "" && !strings.EqualFold(a, answer) {
+					alts = append(alts, a)
+				}
+			}
+			blanks = append(blanks, Blank{Answer: answer, Alternatives: alts})
+		}
+	}
+	if len(blanks) == 0 {
+		return stem, nil
+	}
+
+	switch {
+	case gapPattern.MatchString(stem):
+		stem = gapPattern.ReplaceAllString(stem, blankMarker)
+	case dotGapPattern.MatchString(stem):
+		stem = dotGapPattern.ReplaceAllString(stem, blankMarker)
+	default:
+		// The terms must appear in the order of the blanks
+		from := 0
+		for _, bl := range blanks {
+			start := indexWord(stem[from:], bl.Answer)
+			if start < 0 {
+				return stem, nil
+			}
+			start += from
+			stem = stem[:start] + blankMarker + stem[start+len(bl.Answer):]
+			from = start + len(blankMarker)
+		}
+	}
+	if strings.Count(stem, blankMarker) != len(blanks) {
+		return stem, nil
+	}
+	return stem, blanks
+}
+
+// indexWord finds term in s ignoring case, as a whole word: "re" is found
+// in "il re di Roma" but not in "regola". Like \b, only a letter or digit
+// at the edge of term needs a boundary. It returns -1 if term is absent.
+func indexWord(s, term string) int {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	first, _ := utf8.DecodeRuneInString(term)
+	last, _ := utf8.DecodeLastRuneInString(term)
+	for i := 0; i+len(term) <= len(s); {
+		end := i + len(term)
+		if strings.EqualFold(s[i:end], term) {
+			before, _ := utf8.DecodeLastRuneInString(s[:i])
+			after, _ := utf8.DecodeRuneInString(s[end:])
+			if !(isWord(first) && isWord(before)) && !(isWord(last) && isWord(after)) {
+				return i
+			}
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return -1
+}
+
+func blankAnswers(blanks []Blank) []string {
+	answers := make([]string, len(blanks))
+	for i, bl := range blanks {
+		answers[i] = bl.Answer
+	}
+	return answers
+}
+
+// matchingPairs keeps the complete pairs of a matching question. A left or
+// right item given twice would make the matching ambiguous, so only its
+// first pair is kept.
+func matchingPairs(gps []generatedPair) []Pair {
+	var pairs []Pair
+	seen := map[string]bool{}
+	for _, gp := range gps {
+		left, right := strings.TrimSpace(string(gp.Left)), strings.TrimSpace(string(gp.Right))
+		l, r := "l:"+normalizeAnswer(left), "r:"+normalizeAnswer(right)
+		if left == "" || right == "" || seen[l] || seen[r] {
+			continue
+		}
+		seen[l], seen[r] = true, true
+		pairs = append(pairs, Pair{Left: left, Right: right})
+	}
+	return pairs
+}
+
+// sequenceItems returns the items of a sequence question in their correct
+// order. Models that answer the old way, with the items shuffled in
+// "options" and the order in prose, are understood when the answer
+// mentions every option: the options are then sorted by where they appear.
+func sequenceItems(gq generatedQuestion) []string {
+	var seq []string
+	seen := map[string]bool{}
+	for _, item := range gq.Sequence {
+		item = strings.TrimSpace(item)
+		if key := normalizeAnswer(item); key != "" && !seen[key] {
+			seen[key] = true
+			seq = append(seq, item)
+		}
+	}
+	if len(seq) > 0 || len(gq.Options) < 2 {
+		return seq
+	}
+
+	answer := strings.ToLower(string(gq.Answer))
+	type placed struct {
+		item string
+		at   int
+	}
+	var items []placed
+	for _, opt := range gq.Options {
+		opt = strings.TrimSpace(opt)
+		at := strings.Index(answer, strings.ToLower(opt))
+		if opt == "" || at < 0 {
+			return nil
+		}
+		items = append(items, placed{opt, at})
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].at < items[j].at })
+	for _, p := range items {
+		seq = append(seq, p.item)
+	}
+	return seq
+}
+
+// numericAnswer reads the typed answer of a numeric question, falling back
+// on the answer text when the model left it out or got it wrong.
+func numericAnswer(gn *generatedNumeric, answer string) *NumericAnswer {
+	if gn == nil || !slices.Contains(numericKinds, gn.Kind) {
+		return numericFromText(answer)
+	}
+	n := &NumericAnswer{Kind: gn.Kind}
+	if gn.Kind == numericDate {
+		d, err := parseDate(gn.Date)
+		if err != nil {
+			return numericFromText(answer)
+		}
+		n.Date = d.Format(dateLayout)
+	} else {
+		v, err := modelNumber(gn.Value)
+		if err != nil {
+			return numericFromText(answer)
+		}
+		n.Value = v
+	}
+	if gn.Kind == numericNumber {
+		n.Unit = strings.TrimSpace(gn.Unit)
+	}
+	if tol, err := modelNumber(gn.Tolerance); err == nil && tol > 0 {
+		n.Tolerance = tol
+	}
+	return n
+}
+
+// modelNumber reads a number from the JSON output, which is normally in Go
+// syntax already; quoted numbers in local formats go through parseNumber.
+func modelNumber(f flexString) (float64, error) {
+	if v, err := strconv.ParseFloat(strings.TrimSpace(string(f)), 64); err == nil {
+		return v, nil
+	}
+	return parseNumber(string(f))
+}
+
+// rubricCriteria keeps the criteria that have a name and at least two
+// levels to choose from, with the levels sorted best first.
+func rubricCriteria(grs []generatedRubric) []Criterion {
+	var criteria []Criterion
+	for _, gr := range grs {
+		c := Criterion{Name: strings.TrimSpace(gr.Criterion)}
+		for _, gl := range gr.Levels {
+			p, err := modelNumber(gl.Points)
+			if d := strings.TrimSpace(gl.Description); err == nil && p >= 0 && d != "" {
+				c.Levels = append(c.Levels, RubricLevel{Points: p, Description: d})
+			}
+		}
+		if c.Name == "" || len(c.Levels) < 2 {
+			continue
+		}
+		sort.SliceStable(c.Levels, func(i, j int) bool { return c.Levels[i].Points > c.Levels[j].Points })
+		criteria = append(criteria, c)
+	}
+	return criteria
+}
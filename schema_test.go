@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestCleanJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"plain", `{"questions": []}`, `{"questions": []}`},
+		{"fenced", "```json\n{\"questions\": []}\n```", `{"questions": []}`},
+		{"fence without language", "```\n[1, 2]\n```", `[1, 2]`},
+		{"prose around", `Ecco le domande: {"a": 1} Spero siano utili!`, `{"a": 1}`},
+		{"trailing commas", `{"a": [1, 2, ], "b": 3, }`, `{"a": [1, 2], "b": 3}`},
+		{"typographic quotes", `{“a”: “b”}`, `{"a": "b"}`},
+		{"raw newline in string", "{\"a\": \"riga 1\nriga 2\"}", `{"a": "riga 1\nriga 2"}`},
+		{"brace in string", `{"a": "}"} dopo`, `{"a": "}"}`},
+		{"truncated stays open", `{"questions": [{"text": "Cos'è`, `{"questions": [{"text": "Cos'è`},
+		{"no JSON", "  niente  ", "niente"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanJSON(tt.raw); got != tt.want {
+				t.Errorf("cleanJSON(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloseJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		stack    string
+		inString bool
+		want     string
+	}{
+		{"nothing open", `{"a": 1}`, "", false, `{"a": 1}`},
+		{"open object and array", `{"a": [1, 2`, "}]", false, `{"a": [1, 2]}`},
+		{"open string", `{"a": "tron`, "}", true, `{"a": "tron"}`},
+		{"trailing comma", `[1, 2, `, "]", false, `[1, 2]`},
+		{"dangling key", `{"a":`, "}", false, `{"a":""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := closeJSON(tt.s, []byte(tt.stack), tt.inString)
+			if got != tt.want {
+				t.Errorf("closeJSON(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+			if !json.Valid([]byte(got)) {
+				t.Errorf("closeJSON(%q) = %q is not valid JSON", tt.s, got)
+			}
+		})
+	}
+}
+
+func TestDecodeQuestions(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []string // question texts
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			raw:  `{"questions": [{"id": 1, "text": "A?"}, {"id": 2, "text": "B?"}]}`,
+			want: []string{"A?", "B?"},
+		},
+		{
+			name: "fenced with prose",
+			raw:  "Ecco:\n```json\n{\"questions\": [{\"id\": 1, \"text\": \"A?\"},]}\n```\nFine.",
+			want: []string{"A?"},
+		},
+		{
+			name: "bare array",
+			raw:  `[{"id": "1", "text": "A?"}]`,
+			want: []string{"A?"},
+		},
+		{
+			name: "truncated inside a question drops it",
+			raw:  `{"questions": [{"id": 1, "text": "A?"}, {"id": 2, "text": "B? con una risp`,
+			want: []string{"A?"},
+		},
+		{
+			name: "truncated between questions",
+			raw:  `{"questions": [{"id": 1, "text": "A?"}, {"id": 2, "text": "B?"},`,
+			want: []string{"A?", "B?"},
+		},
+		{
+			name: "truncated in the first question keeps it",
+			raw:  `{"questions": [{"id": 1, "text": "A? tronc`,
+			want: []string{"A? tronc"},
+		},
+		{name: "no questions", raw: `{"questions": []}`, wantErr: true},
+		{name: "not JSON", raw: "Mi dispiace, non posso aiutarti.", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := decodeQuestions(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeQuestions(%q) = %v, want an error", tt.raw, set)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeQuestions(%q): %v", tt.raw, err)
+			}
+			var got []string
+			for _, q := range set.Questions {
+				got = append(got, q.Text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("decodeQuestions(%q) texts = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToQuestionSetTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		question string // one question of a reply
+		want     string
+	}{
+		{"declared type wins over stray fields", `{"type": "multiple_choice", "text": "Capitale?", "choices": [{"text": "Roma", "correct": true}, {"text": "Milano"}], "numeric": {"kind": "number", "value": 3}}`, typeMultipleChoice},
+		{"numeric ignores choices", `{"type": "numeric", "text": "Quanti?", "choices": [{"text": "3", "correct": true}], "numeric": {"kind": "number", "value": 3}}`, typeNumeric},
+		{"undeclared is inferred", `{"text": "Capitale?", "choices": [{"text": "Roma", "correct": true}, {"text": "Milano"}]}`, typeMultipleChoice},
+		{"undeclared without fields", `{"text": "Perché?", "answer": "Perché sì"}`, typeOpen},
+		{"true or false is kept", `{"type": "true_false", "text": "Roma è in Italia.", "answer": "Vero"}`, typeTrueFalse},
+		{"short sequence", `{"type": "sequence", "text": "Ordina", "sequence": ["Uno"]}`, typeOpen},
+		{"essay without rubric or key points", `{"type": "essay", "text": "Discuti", "rubric": []}`, typeOpen},
+		{"unparsable numeric", `{"type": "numeric", "text": "Quanti?", "numeric": {"kind": "number", "value": "tanti"}, "answer": "molti"}`, typeOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, err := decodeQuestions(`{"questions": [` + tt.question + `]}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qs := gs.toQuestionSet()
+			if len(qs.Questions) != 1 || qs.Questions[0].Type != tt.want {
+				t.Errorf("toQuestionSet(%s) = %+v, want one %s question", tt.question, qs.Questions, tt.want)
+			}
+		})
+	}
+
+	gs, err := decodeQuestions(`{"questions": [{"text": "  "}, {"text": "A?"}, {"text": "B?"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qs := gs.toQuestionSet(); len(qs.Questions) != 2 || qs.Questions[0].ID != 1 || qs.Questions[1].ID != 2 {
+		t.Errorf("empty questions aren't dropped and the rest renumbered: %+v", qs.Questions)
+	}
+}
+
+func TestClozeBlanks(t *testing.T) {
+	blanks := func(answers ...string) []generatedBlank {
+		var gbs []generatedBlank
+		for _, a := range answers {
+			gbs = append(gbs, generatedBlank{Answer: flexString(a)})
+		}
+		return gbs
+	}
+	tests := []struct {
+		name      string
+		stem      string
+		blanks    []generatedBlank
+		wantStem  string
+		wantCount int // blanks kept, 0 when the question can't be checked
+	}{
+		{"underscores", "Roma fu fondata nel ___ a.C.", blanks("753"), "Roma fu fondata nel _____ a.C.", 1},
+		{"dots", "La capitale è ... e il fiume è …", blanks("Roma", "Tevere"), "La capitale è _____ e il fiume è _____", 2},
+		{"terms left in place", "La capitale d'Italia è Roma.", blanks("roma"), "La capitale d'Italia è _____.", 1},
+		{"whole words only", "La regola del re è antica.", blanks("re"), "La regola del _____ è antica.", 1},
+		{"accented neighbours", "Nella città di Città del Messico", blanks("città"), "Nella _____ di Città del Messico", 1},
+		{"not inside a word", "La regola è chiara.", blanks("re"), "La regola è chiara.", 0},
+		{"in order", "Prima Cesare, poi Augusto.", blanks("Cesare", "Augusto"), "Prima _____, poi _____.", 2},
+		{"out of order", "Prima Cesare, poi Augusto.", blanks("Augusto", "Cesare"), "", 0},
+		{"more blanks than gaps", "Il ___ è lungo.", blanks("Po", "Tevere"), "", 0},
+		{"empty answers are dropped", "Il ___ è lungo.", blanks("", "Po"), "Il _____ è lungo.", 1},
+		{"no blanks", "Il Po è lungo.", nil, "Il Po è lungo.", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stem, got := clozeBlanks(tt.stem, tt.blanks)
+			if len(got) != tt.wantCount || (tt.wantStem != "" && stem != tt.wantStem) {
+				t.Errorf("clozeBlanks(%q) = %q, %d blanks, want %q, %d", tt.stem, stem, len(got), tt.wantStem, tt.wantCount)
+			}
+		})
+	}
+
+	t.Run("alternatives", func(t *testing.T) {
+		_, got := clozeBlanks("Il ___ è lungo.", []generatedBlank{{Answer: "Po", Alternatives: []string{" po ", "", "Eridano"}}})
+		if len(got) != 1 || !slices.Equal(got[0].Alternatives, []string{"Eridano"}) {
+			t.Errorf("blanks = %+v, want Po with the alternative Eridano only", got)
+		}
+	})
+}
+
+func TestIndexWord(t *testing.T) {
+	tests := []struct {
+		s, term string
+		want    int
+	}{
+		{"il re di Roma", "re", 3},
+		{"regola", "re", -1},
+		{"RE", "re", 0},
+		{"è più", "più", 3},
+		{"capopiù", "più", -1},
+		{"nel 1848.", "1848", 4},
+		{"anno 18480", "1848", -1},
+		{"(a) e (b)", "(b)", 6},
+		{"", "re", -1},
+	}
+	for _, tt := range tests {
+		if got := indexWord(tt.s, tt.term); got != tt.want {
+			t.Errorf("indexWord(%q, %q) = %d, want %d", tt.s, tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestMultipleChoices(t *testing.T) {
+	choices := func(correct ...bool) []generatedChoice {
+		var gcs []generatedChoice
+		for i, c := range correct {
+			gcs = append(gcs, generatedChoice{Text: string(rune('A' + i)), Correct: flexBool(c)})
+		}
+		return gcs
+	}
+	tests := []struct {
+		name      string
+		gq        generatedQuestion
+		wantTexts []string
+		want      int // index of the correct choice, -1 for none
+	}{
+		{"one correct", generatedQuestion{Choices: choices(false, true, false)}, []string{"A", "B", "C"}, 1},
+		{"second key dropped", generatedQuestion{Choices: choices(true, false, true)}, []string{"A", "B", "C"}, 0},
+		{"empty choices dropped", generatedQuestion{Choices: []generatedChoice{{Text: " "}, {Text: " Roma ", Correct: true}}}, []string{"Roma"}, 0},
+		{"key from the answer", generatedQuestion{Choices: choices(false, false), Answer: "b"}, []string{"A", "B"}, 1},
+		{"plain options", generatedQuestion{Options: []string{"Milano", " Roma", ""}, Answer: "roma"}, []string{"Milano", "Roma"}, 1},
+		{"no key", generatedQuestion{Options: []string{"Milano", "Roma"}, Answer: "Napoli"}, []string{"Milano", "Roma"}, -1},
+		{"choices win over options", generatedQuestion{Choices: choices(true), Options: []string{"X"}}, []string{"A"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := multipleChoices(tt.gq)
+			correct := -1
+			for i, c := range got {
+				if c.Correct {
+					if correct >= 0 {
+						t.Fatalf("multipleChoices = %+v, want a single key", got)
+					}
+					correct = i
+				}
+			}
+			if !slices.Equal(choiceTexts(got), tt.wantTexts) || correct != tt.want {
+				t.Errorf("multipleChoices = %q with key %d, want %q with key %d", choiceTexts(got), correct, tt.wantTexts, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchChoice(t *testing.T) {
+	choices := []Choice{{Text: "Roma"}, {Text: "Milano"}, {Text: "Bologna"}}
+	tests := []struct {
+		answer string
+		want   int
+	}{
+		{"Milano", 1},
+		{" roma ", 0},
+		{"c", 2},
+		{"B", 1},
+		{"b) Milano", 1},
+		{"c. Bologna", 2},
+		{"d", -1},
+		{"Napoli", -1},
+		{"", -1},
+	}
+	for _, tt := range tests {
+		if got := matchChoice(choices, tt.answer); got != tt.want {
+			t.Errorf("matchChoice(%q) = %d, want %d", tt.answer, got, tt.want)
+		}
+	}
+}
+
+func TestMatchingPairs(t *testing.T) {
+	tests := []struct {
+		name string
+		gps  []generatedPair
+		want []Pair
+	}{
+		{"trimmed", []generatedPair{{Left: " Roma ", Right: "Lazio "}}, []Pair{{Left: "Roma", Right: "Lazio"}}},
+		{"incomplete dropped", []generatedPair{{Left: "Roma"}, {Right: "Lazio"}, {Left: "Milano", Right: "Lombardia"}}, []Pair{{Left: "Milano", Right: "Lombardia"}}},
+		{"left repeated", []generatedPair{{Left: "Roma", Right: "Lazio"}, {Left: "roma", Right: "Italia"}}, []Pair{{Left: "Roma", Right: "Lazio"}}},
+		{"right repeated", []generatedPair{{Left: "Roma", Right: "Lazio"}, {Left: "Latina", Right: "Lazio."}}, []Pair{{Left: "Roma", Right: "Lazio"}}},
+		{"same text both sides", []generatedPair{{Left: "Po", Right: "fiume"}, {Left: "fiume", Right: "Po"}}, []Pair{{Left: "Po", Right: "fiume"}, {Left: "fiume", Right: "Po"}}},
+		{"none", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchingPairs(tt.gps); !slices.Equal(got, tt.want) {
+				t.Errorf("matchingPairs = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRubricCriteria(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []Criterion
+	}{
+		{
+			"levels sorted best first",
+			`[{"criterion": " Chiarezza ", "levels": [{"points": 1, "description": "confusa"}, {"points": "3", "description": "chiara"}, {"points": 2, "description": "quasi chiara"}]}]`,
+			[]Criterion{{Name: "Chiarezza", Levels: []RubricLevel{{3, "chiara"}, {2, "quasi chiara"}, {1, "confusa"}}}},
+		},
+		{
+			"bad levels dropped",
+			`[{"criterion": "Fonti", "levels": [{"points": -1, "description": "nessuna"}, {"points": "molti", "description": "tante"}, {"points": 2, "description": " "}, {"points": 0, "description": "nessuna"}, {"points": "1,5", "description": "alcune"}]}]`,
+			[]Criterion{{Name: "Fonti", Levels: []RubricLevel{{1.5, "alcune"}, {0, "nessuna"}}}},
+		},
+		{
+			"criteria without a choice dropped",
+			`[{"criterion": "", "levels": [{"points": 1, "description": "a"}, {"points": 0, "description": "b"}]}, {"criterion": "Lessico", "levels": [{"points": 1, "description": "buono"}]}]`,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var grs []generatedRubric
+			if err := json.Unmarshal([]byte(tt.raw), &grs); err != nil {
+				t.Fatal(err)
+			}
+			got := rubricCriteria(grs)
+			if len(got) != len(tt.want) {
+				t.Fatalf("rubricCriteria = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Name != tt.want[i].Name || !slices.Equal(got[i].Levels, tt.want[i].Levels) {
+					t.Errorf("criterion %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServeAddr = "127.0.0.1:8787"
+	envServeToken    = "LAZYQ_SERVE_TOKEN"
+	jobRetention     = time.Hour // finished jobs are forgotten after this
+)
+
+// Job states, as reported by the API
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// job is one generation submitted through the API.
+type job struct {
+	ID         string       `json:"id"`
+	Status     string       `json:"status"`
+	Error      string       `json:"error,omitempty"`
+	Files      []string     `json:"files"`
+	Options    jobOptions   `json:"options"`
+	CreatedAt  time.Time    `json:"created_at"`
+	StartedAt  *time.Time   `json:"started_at,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Result     *QuestionSet `json:"result,omitempty"`
+	Usage      *Usage       `json:"usage,omitempty"` // also set for failed jobs
+	Estimate   float64      `json:"estimated_cost_usd,omitempty"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type jobOptions struct {
+	Models   []string     `json:"models"` // tried in order
+	N        int          `json:"n"`
+	Style    string       `json:"style,omitempty"`
+	Mix      []styleShare `json:"mix,omitempty"`
+	Language string       `json:"lang,omitempty"`
+}
+
+// apiServer runs generations for HTTP clients. Jobs live in memory only.
+type apiServer struct {
+	provider      Provider
+	defaultModels []string
+	token         string        // required as "Authorization: Bearer <token>" when set
+	maxUpload     int64         // bytes per request
+	slots         chan struct{} // bounds concurrent generations
+	budget        budget
+	paid          bool           // the provider bills requests, so unpriced models are refused under a cap
+	catalogs      []modelCatalog // for the context length of the requested models
+
+	budgetMu sync.Mutex
+	reserved float64 // estimated cost of the jobs accepted and not yet recorded
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	pf := addProviderFlags(fs)
+	addr := fs.String("addr", defaultServeAddr, "address to listen on")
+	model := fs.String("model", "", "model, or comma-separated models tried in order, used when a request doesn't name one (default depends on the provider)")
+	workers := fs.Int("workers", 2, "generations running at the same time")
+	maxUpload := fs.Int("max-upload", 50, "maximum upload size per request, in MB")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lazyq serve [flags]\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nClients must send \"Authorization: Bearer <token>\" when %s is set.\n", envServeToken)
+		fmt.Fprintf(fs.Output(), "The provider API key is read from %s (or OPENROUTER_API_KEY).\n", envAPIKey)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	fail := func(format string, a ...interface{}) int {
+		fmt.Fprintf(os.Stderr, "lazyq serve: "+format+"\n", a...)
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		return fail("unexpected argument %q", fs.Arg(0))
+	}
+	if *workers < 1 {
+		return fail("--workers must be at least 1")
+	}
+
+	cfg, err := pf.config()
+	if err != nil {
+		return fail("%v", err)
+	}
+	provider, err := newProvider(cfg)
+	if err != nil {
+		return fail("%v", err)
+	}
+	var b budget
+	if err := b.applyEnv(); err != nil {
+		return fail("%v", err)
+	}
+	s := &apiServer{
+		provider:      provider,
+		defaultModels: parseModelList(*model),
+		token:         strings.TrimSpace(os.Getenv(envServeToken)),
+		maxUpload:     int64(*maxUpload) << 20,
+		slots:         make(chan struct{}, *workers),
+		budget:        b,
+		paid:          cfg.needsKey(),
+		catalogs:      knownCatalogs(cfg, true),
+		jobs:          map[string]*job{},
+	}
+	if len(s.defaultModels) == 0 {
+		s.defaultModels = []string{defaultModelFor(cfg.Kind)}
+	}
+	if s.token == "" && !isLocalEndpoint("http://"+*addr) {
+		log.Printf("warning: listening on %s without %s, anyone who can reach it can spend your credits", *addr, envServeToken)
+	}
+	go s.expireJobs()
+
+	log.Printf("LazyQ API on http://%s (%s, default model %s)", *addr, providerLabel(cfg.Kind), strings.Join(s.defaultModels, ", "))
+	if err := http.ListenAndServe(*addr, s.routes()); err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func (s *apiServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("POST /v1/jobs", s.auth(s.handleSubmit))
+	mux.HandleFunc("GET /v1/jobs/{id}", s.auth(s.handleStatus))
+	mux.HandleFunc("GET /v1/jobs/{id}/result", s.auth(s.handleResult))
+	mux.HandleFunc("DELETE /v1/jobs/{id}", s.auth(s.handleDelete))
+	mux.HandleFunc("POST /v1/generate", s.auth(s.handleGenerate))
+	return mux
+}
+
+func (s *apiServer) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "missing or invalid token")
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// handleSubmit queues a generation and returns its ID right away; clients
+// poll GET /v1/jobs/{id} until the status is done or failed.
+func (s *apiServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	j, mat, err := s.parseRequest(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.checkBudget(j, mat); err != nil {
+		writeError(w, budgetStatus(err), err.Error())
+		return
+	}
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+	go s.run(j, mat)
+
+	w.Header().Set("Location", "/v1/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, s.snapshot(j))
+}
+
+// handleGenerate runs a generation within the request and returns the
+// QuestionSet, for clients that don't want to poll. A client that hangs up
+// aborts the generation.
+func (s *apiServer) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	j, mat, err := s.parseRequest(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.checkBudget(j, mat); err != nil {
+		writeError(w, budgetStatus(err), err.Error())
+		return
+	}
+	j.ctx, j.cancel = context.WithCancel(r.Context())
+	s.run(j, mat)
+	if j.Status == jobFailed {
+		writeError(w, http.StatusBadGateway, j.Error)
+		return
+	}
+	writeJSON(w, http.StatusOK, j.Result)
+}
+
+// checkBudget estimates the cost of j and refuses it if it would exceed
+// the spending caps.
+func (s *apiServer) checkBudget(j *job, mat material) error {
+	opts := generationOptions{Model: j.Options.Models[0], N: j.Options.N, Style: j.Options.Style, Mix: j.Options.Mix, ContextLength: contextLength(j.Options.Models, s.catalogs...)}
+	est, priced := estimateUsage(opts, mat, loadPrices())
+	if !priced {
+		return s.budget.checkRun(opts.Model, 0, false, s.paid)
+	}
+	// Jobs accepted but not finished aren't in the ledger yet: their
+	// estimates are reserved, so that simultaneous submissions can't each
+	// pass against the same total
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+	if err := s.budget.check(s.reserved, est.Cost); err != nil {
+		return err
+	}
+	j.Estimate = est.Cost
+	s.reserved += est.Cost
+	return nil
+}
+
+// budgetStatus is the HTTP status for an error of checkBudget: 402 when the
+// run is refused, 500 when the ledger couldn't be read.
+func budgetStatus(err error) int {
+	var be *budgetError
+	var ue *unpricedError
+	if errors.As(err, &be) || errors.As(err, &ue) {
+		return http.StatusPaymentRequired
+	}
+	return http.StatusInternalServerError
+}
+
+// release frees the estimate reserved for j once its run is in the ledger.
+func (s *apiServer) release(j *job) {
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+	s.reserved = max(0, s.reserved-j.Estimate)
+}
+
+func (s *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	j := s.lookup(r.PathValue("id"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, "no such job")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.snapshot(j))
+}
+
+// handleResult returns just the QuestionSet, as JSON or, with ?format=text,
+// as the same text that "Salva Domande" writes.
+func (s *apiServer) handleResult(w http.ResponseWriter, r *http.Request) {
+	j := s.lookup(r.PathValue("id"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, "no such job")
+		return
+	}
+	snap := s.snapshot(j)
+	switch snap.Status {
+	case jobFailed:
+		writeError(w, http.StatusBadGateway, snap.Error)
+		return
+	case jobQueued, jobRunning:
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusConflict, "job is still "+snap.Status)
+		return
+	}
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, snap.Result.Text()+"\n")
+		return
+	}
+	writeJSON(w, http.StatusOK, snap.Result)
+}
+
+// handleDelete forgets a job, aborting it first if it is still queued or
+// running.
+func (s *apiServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	delete(s.jobs, id)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such job")
+		return
+	}
+	j.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseRequest reads a multipart form with one or more "files" parts and
+// the optional fields n, style, mix, lang and model.
+func (s *apiServer) parseRequest(w http.ResponseWriter, r *http.Request) (*job, material, error) {
+	var mat material
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	if err := r.ParseMultipartForm(s.maxUpload); err != nil {
+		return nil, mat, fmt.Errorf("expected a multipart form with files: %w", err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	opts := jobOptions{
+		Models:   parseModelList(r.FormValue("model")),
+		N:        defaultN,
+		Language: strings.TrimSpace(r.FormValue("lang")),
+	}
+	if len(opts.Models) == 0 {
+		opts.Models = s.defaultModels
+	}
+	if v := strings.TrimSpace(r.FormValue("n")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return nil, mat, errors.New("n must be between 1 and 100")
+		}
+		opts.N = n
+	}
+	style, err := parseStyle(r.FormValue("style"))
+	if err != nil {
+		return nil, mat, err
+	}
+	opts.Style = style
+	if v := strings.TrimSpace(r.FormValue("mix")); v != "" {
+		if style != "" {
+			return nil, mat, errors.New("mix and style can't be used together")
+		}
+		mix, err := parseMix(v)
+		if err != nil {
+			return nil, mat, fmt.Errorf("mix: %w", err)
+		}
+		opts.Mix, opts.N = mix, mixTotal(mix)
+	}
+
+	var files []string
+	for _, fh := range r.MultipartForm.File["files"] {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, mat, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, mat, err
+		}
+		if err := mat.add(fh.Filename, data); err != nil {
+			return nil, mat, fmt.Errorf("%s: %w", fh.Filename, err)
+		}
+		files = append(files, fh.Filename)
+	}
+	if mat.empty() {
+		return nil, mat, errors.New("no files: send PDFs or images as \"files\" parts")
+	}
+
+	return &job{
+		ID:        newJobID(),
+		Status:    jobQueued,
+		Files:     files,
+		Options:   opts,
+		CreatedAt: time.Now(),
+	}, mat, nil
+}
+
+// run waits for a free slot and generates the questions of j.
+func (s *apiServer) run(j *job, mat material) {
+	defer j.cancel()
+	defer s.release(j)
+	select {
+	case s.slots <- struct{}{}:
+	case <-j.ctx.Done():
+		s.finish(j, time.Now(), nil, Usage{}, j.ctx.Err())
+		return
+	}
+	defer func() { <-s.slots }()
+
+	started := time.Now()
+	s.mu.Lock()
+	j.Status = jobRunning
+	j.StartedAt = &started
+	s.mu.Unlock()
+
+	opts := generationOptions{
+		Model:         j.Options.Models[0],
+		Fallbacks:     j.Options.Models[1:],
+		N:             j.Options.N,
+		Style:         j.Options.Style,
+		Mix:           j.Options.Mix,
+		Language:      j.Options.Language,
+		ContextLength: contextLength(j.Options.Models, s.catalogs...),
+	}
+	qs, usage, err := generateQuestionSet(j.ctx, s.provider, opts, mat)
+	usage = recordRun("api", s.provider.Name(), opts, qs, usage, err)
+
+	s.finish(j, started, qs, usage, err)
+}
+
+func (s *apiServer) finish(j *job, started time.Time, qs *QuestionSet, usage Usage, err error) {
+	finished := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.FinishedAt = &finished
+	if !usage.Zero() {
+		j.Usage = &usage
+	}
+	if err != nil {
+		j.Status = jobFailed
+		j.Error = err.Error()
+		log.Printf("job %s failed: %v", j.ID, err)
+		return
+	}
+	j.Status = jobDone
+	j.Result = qs
+	log.Printf("job %s: %d questions in %s", j.ID, len(qs.Questions), finished.Sub(started).Truncate(time.Millisecond))
+}
+
+func (s *apiServer) lookup(id string) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// snapshot copies j under the lock so it can be encoded while the job runs.
+func (s *apiServer) snapshot(j *job) job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *j
+}
+
+func (s *apiServer) expireJobs() {
+	for range time.Tick(time.Minute) {
+		s.mu.Lock()
+		for id, j := range s.jobs {
+			if j.FinishedAt != nil && time.Since(*j.FinishedAt) > jobRetention {
+				delete(s.jobs, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
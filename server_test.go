@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBudgetStatus(t *testing.T) {
+	ledger := filepath.Join(t.TempDir(), "usage.csv")
+	if err := os.WriteFile(ledger, []byte("not,a,ledger\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envLedger, ledger)
+	b := budget{Day: 1}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"over the cap", &budgetError{Period: periodDay, Limit: 1, Spent: 0.9, Estimate: 0.2}, http.StatusPaymentRequired},
+		{"wrapped refusal", fmt.Errorf("job: %w", &budgetError{Period: periodMonth}), http.StatusPaymentRequired},
+		{"unpriced model", b.checkRun("sconosciuto/modello", 0, false, true), http.StatusPaymentRequired},
+		{"unreadable ledger", b.check(0, 0.1), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err == nil {
+				t.Fatal("no error to map")
+			}
+			if got := budgetStatus(tt.err); got != tt.want {
+				t.Errorf("budgetStatus(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
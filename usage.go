@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// envLedger overrides where the usage ledger is kept.
+const envLedger = "LAZYQ_LEDGER"
+
+// Usage is what one or more requests consumed, as reported by the provider.
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost_usd"` // 0 when the provider doesn't report a price
+}
+
+// Add sums o into u.
+func (u *Usage) Add(o Usage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.Cost += o.Cost
+}
+
+// Zero reports whether nothing was consumed, or nothing was reported.
+func (u Usage) Zero() bool {
+	return u.PromptTokens == 0 && u.CompletionTokens == 0 && u.Cost == 0
+}
+
+// formatCost prints a dollar amount, with up to four decimals below a
+// dollar so that cheap runs don't show as $0.00.
+func formatCost(c float64) string {
+	if c <= 0 || c >= 1 {
+		return fmt.Sprintf("$%.2f", c)
+	}
+	s := strings.TrimRight(fmt.Sprintf("%.4f", c), "0")
+	if len(s) < 4 { // at least cents, as in 0.8 -> 0.80
+		s += strings.Repeat("0", 4-len(s))
+	}
+	return "$" + s
+}
+
+// Ledger statuses
+const (
+	runOK        = "ok"
+	runFailed    = "failed"
+	runCancelled = "cancelled"
+)
+
+// ledgerEntry is one generation run. Failed and cancelled runs are recorded
+// too, since the tokens they used are billed all the same.
+type ledgerEntry struct {
+	Time      time.Time
+	Origin    string // "app", "cli" or "api"
+	Provider  string
+	Model     string
+	Questions int
+	Status    string
+	Usage     Usage
+}
+
+var ledgerHeader = []string{"time", "origin", "provider", "model", "questions", "status", "prompt_tokens", "completion_tokens", "cost_usd"}
+
+func (e ledgerEntry) record() []string {
+	return []string{
+		e.Time.Format(time.RFC3339),
+		e.Origin,
+		e.Provider,
+		e.Model,
+		strconv.Itoa(e.Questions),
+		e.Status,
+		strconv.Itoa(e.Usage.PromptTokens),
+		strconv.Itoa(e.Usage.CompletionTokens),
+		strconv.FormatFloat(e.Usage.Cost, 'f', 6, 64),
+	}
+}
+
+func parseLedgerRecord(rec []string) (ledgerEntry, error) {
+	if len(rec) != len(ledgerHeader) {
+		return ledgerEntry{}, fmt.Errorf("expected %d fields, got %d", len(ledgerHeader), len(rec))
+	}
+	var e ledgerEntry
+	var err error
+	if e.Time, err = time.Parse(time.RFC3339, rec[0]); err != nil {
+		return e, err
+	}
+	e.Origin, e.Provider, e.Model, e.Status = rec[1], rec[2], rec[3], rec[5]
+	if e.Questions, err = strconv.Atoi(rec[4]); err != nil {
+		return e, err
+	}
+	if e.Usage.PromptTokens, err = strconv.Atoi(rec[6]); err != nil {
+		return e, err
+	}
+	if e.Usage.CompletionTokens, err = strconv.Atoi(rec[7]); err != nil {
+		return e, err
+	}
+	if e.Usage.Cost, err = strconv.ParseFloat(rec[8], 64); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// ledgerPath is the CSV file where every run is appended. It is shared by
+// the app, the command line and the REST API.
+func ledgerPath() (string, error) {
+	if p := strings.TrimSpace(os.Getenv(envLedger)); p != "" {
+		return p, nil
+	}
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "usage.csv"), nil
+}
+
+var ledgerMu sync.Mutex
+
+// appendLedger records a run. Runs that used nothing (e.g. rejected before
+// the first token) are not worth a line.
+func appendLedger(e ledgerEntry) error {
+	if e.Usage.Zero() && e.Status != runOK {
+		return nil
+	}
+	path, err := ledgerPath()
+	if err != nil {
+		return err
+	}
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+		w.Write(ledgerHeader)
+	}
+	w.Write(e.record())
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readLedger returns every recorded run, oldest first. A missing ledger is
+// simply empty.
+func readLedger() ([]ledgerEntry, error) {
+	path, err := ledgerPath()
+	if err != nil {
+		return nil, err
+	}
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	var entries []ledgerEntry
+	for line := 1; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, err
+		}
+		if line == 1 && len(rec) > 0 && rec[0] == ledgerHeader[0] {
+			continue
+		}
+		e, err := parseLedgerRecord(rec)
+		if err != nil {
+			return entries, fmt.Errorf("%s line %d: %v", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+// writeLedgerCSV exports runs in the ledger's own format.
+func writeLedgerCSV(w io.Writer, entries []ledgerEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write(ledgerHeader)
+	for _, e := range entries {
+		cw.Write(e.record())
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Periods for ledgerTotals, as time layouts of the period key.
+const (
+	periodDay   = "2006-01-02"
+	periodMonth = "2006-01"
+)
+
+// ledgerTotal sums the runs of one day or month.
+type ledgerTotal struct {
+	Period string
+	Runs   int
+	Usage  Usage
+}
+
+// ledgerTotals groups runs by day or month in local time, newest first.
+func ledgerTotals(entries []ledgerEntry, period string) []ledgerTotal {
+	byKey := map[string]*ledgerTotal{}
+	var totals []*ledgerTotal
+	for _, e := range entries {
+		key := e.Time.Local().Format(period)
+		t, ok := byKey[key]
+		if !ok {
+			t = &ledgerTotal{Period: key}
+			byKey[key] = t
+			totals = append(totals, t)
+		}
+		t.Runs++
+		t.Usage.Add(e.Usage)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Period > totals[j].Period })
+	out := make([]ledgerTotal, len(totals))
+	for i, t := range totals {
+		out[i] = *t
+	}
+	return out
+}
+
+// ledgerTotalFor is the total of the period containing t.
+func ledgerTotalFor(entries []ledgerEntry, period string, t time.Time) ledgerTotal {
+	key := t.Local().Format(period)
+	for _, total := range ledgerTotals(entries, period) {
+		if total.Period == key {
+			return total
+		}
+	}
+	return ledgerTotal{Period: key}
+}
+
+// localProviders are the providers, by name, that run models on the
+// user's own machine and cost nothing.
+var localProviders = map[string]bool{"Ollama": true, "llama.cpp": true}
+
+// recordRun appends a finished run to the ledger; a ledger that can't be
+// written must not fail the generation, so errors are only logged. Only
+// OpenRouter reports what a reply cost: for the other cloud providers the
+// cost is worked out from the tokens at list price, so that the caps count
+// that spending too. It returns the usage as recorded.
+func recordRun(origin, provider string, opts generationOptions, qs *QuestionSet, usage Usage, err error) Usage {
+	e := ledgerEntry{Time: time.Now(), Origin: origin, Provider: provider, Model: opts.Model, Status: runOK, Usage: usage}
+	switch {
+	case errors.Is(err, context.Canceled):
+		e.Status = runCancelled
+	case err != nil:
+		e.Status = runFailed
+	}
+	if qs != nil {
+		e.Questions = len(qs.Questions)
+		if qs.Model != "" {
+			e.Model = qs.Model
+		}
+	}
+	if e.Usage.Cost == 0 && !localProviders[provider] {
+		if price, ok := loadPrices().lookup(e.Model); ok {
+			e.Usage.Cost = price.cost(e.Usage, 0)
+		}
+	}
+	if lerr := appendLedger(e); lerr != nil {
+		log.Printf("usage ledger: %v", lerr)
+	}
+	return e.Usage
+}
+
+// Spending caps, in dollars. They take precedence over the app settings and
+// are the only way to set caps for the command line and the REST API.
+const (
+	envBudgetDay   = "LAZYQ_BUDGET_DAY"
+	envBudgetMonth = "LAZYQ_BUDGET_MONTH"
+)
+
+// budget caps what generations may spend, in dollars; 0 means no cap.
+type budget struct {
+	Day   float64
+	Month float64
+}
+
+// parseBudget reads an amount in dollars; empty means no cap.
+func parseBudget(text string) (float64, error) {
+	text = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), "$"))
+	if text == "" {
+		return 0, nil
+	}
+	// Accept the Italian decimal comma
+	v, err := strconv.ParseFloat(strings.Replace(text, ",", ".", 1), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid budget %q, expected an amount in dollars", text)
+	}
+	return v, nil
+}
+
+func (b *budget) applyEnv() error {
+	var err error
+	if v, ok := os.LookupEnv(envBudgetDay); ok {
+		if b.Day, err = parseBudget(v); err != nil {
+			return fmt.Errorf("%s: %w", envBudgetDay, err)
+		}
+	}
+	if v, ok := os.LookupEnv(envBudgetMonth); ok {
+		if b.Month, err = parseBudget(v); err != nil {
+			return fmt.Errorf("%s: %w", envBudgetMonth, err)
+		}
+	}
+	return nil
+}
+
+// budgetError is returned when a run would take the spending of the day or
+// month past its cap.
+type budgetError struct {
+	Period   string // periodDay or periodMonth
+	Limit    float64
+	Spent    float64
+	Pending  float64 // estimated cost of the runs accepted but not yet in the ledger
+	Estimate float64
+}
+
+func (e *budgetError) Error() string {
+	name := "monthly"
+	if e.Period == periodDay {
+		name = "daily"
+	}
+	spent := formatCost(e.Spent) + " already spent"
+	if e.Pending > 0 {
+		spent += fmt.Sprintf(", ~%s in runs in progress", formatCost(e.Pending))
+	}
+	return fmt.Sprintf("%s budget of %s exceeded: %s, this run would cost ~%s", name, formatCost(e.Limit), spent, formatCost(e.Estimate))
+}
+
+// unpricedError is returned when caps are set but the price of the model
+// isn't known, so a run can't be checked against them.
+type unpricedError struct {
+	Model string
+}
+
+func (e *unpricedError) Error() string {
+	return fmt.Sprintf("the price of %s is unknown, so the spending caps can't be enforced", e.Model)
+}
+
+// set reports whether there is at least one cap.
+func (b budget) set() bool {
+	return b.Day > 0 || b.Month > 0
+}
+
+// checkRun checks a run with model against the caps, given its estimated
+// cost and whether the model's price is known. Unpriced models are refused
+// when the provider bills requests, and taken to be free otherwise.
+func (b budget) checkRun(model string, estimate float64, priced, paid bool) error {
+	if !b.set() {
+		return nil
+	}
+	if !priced {
+		if paid {
+			return &unpricedError{Model: model}
+		}
+		return nil
+	}
+	return b.check(0, estimate)
+}
+
+// check refuses a run of the given estimated cost if it would exceed the
+// caps, counting what the ledger says was spent today and this month plus
+// pending, the estimates of the runs that haven't been recorded yet.
+func (b budget) check(pending, estimate float64) error {
+	if !b.set() {
+		return nil
+	}
+	entries, err := readLedger()
+	if err != nil {
+		return fmt.Errorf("can't check the budget: %w", err)
+	}
+	now := time.Now()
+	caps := []struct {
+		period string
+		limit  float64
+	}{{periodDay, b.Day}, {periodMonth, b.Month}}
+	for _, c := range caps {
+		if c.limit <= 0 {
+			continue
+		}
+		spent := ledgerTotalFor(entries, c.period, now).Usage.Cost
+		if spent+pending+estimate > c.limit {
+			return &budgetError{Period: c.period, Limit: c.limit, Spent: spent, Pending: pending, Estimate: estimate}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useTempLedger points the ledger and the config dir (for the cached price
+// list) at a fresh directory.
+func useTempLedger(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(envLedger, filepath.Join(dir, "usage.csv"))
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+}
+
+func TestLedgerRoundTrip(t *testing.T) {
+	useTempLedger(t)
+	now := time.Now().Truncate(time.Second)
+	entries := []ledgerEntry{
+		{Time: now.Add(-time.Hour), Origin: "cli", Provider: "OpenRouter", Model: "openai/gpt-4o", Questions: 10, Status: runOK, Usage: Usage{PromptTokens: 1200, CompletionTokens: 800, Cost: 0.011}},
+		{Time: now, Origin: "app", Provider: "Compatibile OpenAI", Model: `modello "strano", con virgola`, Status: runFailed, Usage: Usage{PromptTokens: 300}},
+	}
+	// Written out of order: the ledger is read oldest first
+	for _, e := range []ledgerEntry{entries[1], entries[0]} {
+		if err := appendLedger(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A cancelled run that used nothing isn't worth a line
+	if err := appendLedger(ledgerEntry{Time: now, Status: runCancelled}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		got[i].Time = got[i].Time.Local()
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("readLedger() = %+v, want %+v", got, entries)
+	}
+
+	// The export has the same format
+	var buf bytes.Buffer
+	if err := writeLedgerCSV(&buf, got); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != len(entries)+1 || !reflect.DeepEqual(recs[0], ledgerHeader) {
+		t.Fatalf("export = %q", recs)
+	}
+	for i, rec := range recs[1:] {
+		e, err := parseLedgerRecord(rec)
+		if err != nil || !e.Time.Equal(entries[i].Time) || e.Model != entries[i].Model || e.Usage != entries[i].Usage {
+			t.Errorf("exported line %d = %+v, %v, want %+v", i+1, e, err, entries[i])
+		}
+	}
+}
+
+func TestReadLedgerMissing(t *testing.T) {
+	useTempLedger(t)
+	if entries, err := readLedger(); err != nil || entries != nil {
+		t.Errorf("readLedger() = %v, %v, want nothing", entries, err)
+	}
+}
+
+func TestParseLedgerRecord(t *testing.T) {
+	good := []string{"2024-05-02T10:00:00Z", "api", "OpenRouter", "openai/gpt-4o", "5", "ok", "100", "50", "0.001000"}
+	tests := []struct {
+		name    string
+		edit    func(rec []string) []string
+		wantErr bool
+	}{
+		{"good", func(rec []string) []string { return rec }, false},
+		{"missing field", func(rec []string) []string { return rec[:8] }, true},
+		{"bad time", func(rec []string) []string { rec[0] = "ieri"; return rec }, true},
+		{"bad tokens", func(rec []string) []string { rec[6] = "molti"; return rec }, true},
+		{"bad cost", func(rec []string) []string { rec[8] = "$1"; return rec }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := tt.edit(append([]string(nil), good...))
+			_, err := parseLedgerRecord(rec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseLedgerRecord(%q) error = %v, want error %v", rec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseBudget(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    float64
+		wantErr bool
+	}{
+		{text: "", want: 0},
+		{text: "5", want: 5},
+		{text: " $2.50 ", want: 2.5},
+		{text: "0,75", want: 0.75},
+		{text: "-1", wantErr: true},
+		{text: "cinque", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := parseBudget(tt.text)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseBudget(%q) = %v, %v, want %v (error %v)", tt.text, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBudgetCheck(t *testing.T) {
+	useTempLedger(t)
+	now := time.Now()
+	// 40 days ago is always another day and another month
+	for _, e := range []ledgerEntry{
+		{Time: now, Status: runOK, Usage: Usage{Cost: 0.5}},
+		{Time: now.AddDate(0, 0, -40), Status: runOK, Usage: Usage{Cost: 10}},
+	} {
+		if err := appendLedger(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name              string
+		b                 budget
+		pending, estimate float64
+		wantPeriod        string // "" for no error
+	}{
+		{"no caps", budget{}, 0, 100, ""},
+		{"within the day", budget{Day: 1}, 0, 0.4, ""},
+		{"over the day", budget{Day: 1}, 0, 0.6, periodDay},
+		{"pending counts", budget{Day: 1}, 0.3, 0.3, periodDay},
+		{"over the month", budget{Day: 5, Month: 0.8}, 0, 0.4, periodMonth},
+		{"old runs don't count", budget{Month: 1}, 0, 0.4, ""},
+		{"exactly at the cap", budget{Day: 1}, 0, 0.5, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.b.check(tt.pending, tt.estimate)
+			var be *budgetError
+			switch {
+			case tt.wantPeriod == "" && err != nil:
+				t.Errorf("check(%v, %v) = %v, want nil", tt.pending, tt.estimate, err)
+			case tt.wantPeriod != "" && (!errors.As(err, &be) || be.Period != tt.wantPeriod):
+				t.Errorf("check(%v, %v) = %v, want a %s budget error", tt.pending, tt.estimate, err, tt.wantPeriod)
+			case be != nil && (be.Spent != 0.5 || be.Pending != tt.pending):
+				t.Errorf("check(%v, %v) = %+v, want 0.5 spent", tt.pending, tt.estimate, be)
+			}
+		})
+	}
+}
+
+func TestCheckRun(t *testing.T) {
+	useTempLedger(t)
+	tests := []struct {
+		name     string
+		b        budget
+		estimate float64
+		priced   bool
+		paid     bool
+		want     string // "", "budget" or "unpriced"
+	}{
+		{"no caps, unpriced", budget{}, 0, false, true, ""},
+		{"unpriced paid model", budget{Day: 1}, 0, false, true, "unpriced"},
+		{"unpriced local model", budget{Day: 1}, 0, false, false, ""},
+		{"priced within", budget{Month: 1}, 0.2, true, true, ""},
+		{"priced over", budget{Month: 1}, 2, true, true, "budget"},
+		{"local but over", budget{Month: 1}, 2, true, false, "budget"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.b.checkRun("m", tt.estimate, tt.priced, tt.paid)
+			var be *budgetError
+			var ue *unpricedError
+			got := ""
+			switch {
+			case errors.As(err, &be):
+				got = "budget"
+			case errors.As(err, &ue):
+				got = "unpriced"
+			case err != nil:
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("checkRun = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordRunPrices(t *testing.T) {
+	useTempLedger(t)
+	gpt := builtinPrices["openai/gpt-4o"]
+	used := Usage{PromptTokens: 1000, CompletionTokens: 100}
+	tests := []struct {
+		name     string
+		provider string
+		model    string
+		usage    Usage
+		want     float64
+	}{
+		{"reported cost is kept", "OpenRouter", "openai/gpt-4o", Usage{PromptTokens: 1000, CompletionTokens: 100, Cost: 0.02}, 0.02},
+		{"priced from the list", "OpenAI", "gpt-4o", used, 1000*gpt.Prompt + 100*gpt.Completion},
+		{"local is free", "Ollama", "gpt-4o", used, 0},
+		{"unpriced", "OpenAI", "sconosciuto", used, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := recordRun("cli", tt.provider, generationOptions{Model: tt.model}, nil, tt.usage, nil)
+			if math.Abs(got.Cost-tt.want) > 1e-12 || got.PromptTokens != tt.usage.PromptTokens {
+				t.Errorf("recordRun(%s, %s) = %+v, want cost %v", tt.provider, tt.model, got, tt.want)
+			}
+		})
+	}
+	entries, err := readLedger()
+	if err != nil || len(entries) != len(tests) {
+		t.Fatalf("ledger has %d runs, %v, want %d", len(entries), err, len(tests))
+	}
+}
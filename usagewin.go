@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// usageText describes what a run consumed, for the footer of the questions.
+func usageText(u Usage) string {
+	text := fmt.Sprintf("Token: %d in ingresso, %d in uscita", u.PromptTokens, u.CompletionTokens)
+	if u.Cost > 0 {
+		return text + ", costo " + formatCost(u.Cost)
+	}
+	return text + " (nessun costo: modello locale o assente dal listino prezzi)"
+}
+
+// showUsageDialog shows the daily and monthly totals of the usage ledger
+// and lets the user export every recorded run as CSV.
+func showUsageDialog(w fyne.Window) {
+	entries, err := readLedger()
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("impossibile leggere il registro dei consumi: %w", err), w)
+		return
+	}
+
+	now := time.Now()
+	summary := func(label string, t ledgerTotal) string {
+		return fmt.Sprintf("%s: %d generazioni, %d token, %s", label, t.Runs, t.Usage.PromptTokens+t.Usage.CompletionTokens, formatCost(t.Usage.Cost))
+	}
+	totalsLabel := widget.NewLabel(summary("Oggi", ledgerTotalFor(entries, periodDay, now)) + "\n" +
+		summary("Questo mese", ledgerTotalFor(entries, periodMonth, now)))
+
+	columns := []string{"Periodo", "Generazioni", "Token in", "Token out", "Costo"}
+	var rows []ledgerTotal
+	table := widget.NewTable(
+		func() (int, int) { return len(rows) + 1, len(columns) },
+		func() fyne.CanvasObject { return widget.NewLabel("0000-00-00 0000") },
+		func(id widget.TableCellID, o fyne.CanvasObject) {
+			label := o.(*widget.Label)
+			if id.Row == 0 {
+				label.TextStyle = fyne.TextStyle{Bold: true}
+				label.SetText(columns[id.Col])
+				return
+			}
+			label.TextStyle = fyne.TextStyle{}
+			t := rows[id.Row-1]
+			switch id.Col {
+			case 0:
+				label.SetText(t.Period)
+			case 1:
+				label.SetText(fmt.Sprintf("%d", t.Runs))
+			case 2:
+				label.SetText(fmt.Sprintf("%d", t.Usage.PromptTokens))
+			case 3:
+				label.SetText(fmt.Sprintf("%d", t.Usage.CompletionTokens))
+			case 4:
+				label.SetText(formatCost(t.Usage.Cost))
+			}
+		},
+	)
+
+	periodRadio := widget.NewRadioGroup([]string{"Per mese", "Per giorno"}, func(s string) {
+		if s == "Per giorno" {
+			rows = ledgerTotals(entries, periodDay)
+		} else {
+			rows = ledgerTotals(entries, periodMonth)
+		}
+		table.Refresh()
+	})
+	periodRadio.Horizontal = true
+	periodRadio.SetSelected("Per mese")
+
+	exportBtn := widget.NewButtonWithIcon("Esporta CSV", theme.DocumentSaveIcon(), func() {
+		fs := dialog.NewFileSave(func(wc fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if wc == nil {
+				return
+			}
+			defer wc.Close()
+			if err := writeLedgerCSV(wc, entries); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			dialog.ShowInformation("Esportato", fmt.Sprintf("%d generazioni esportate.", len(entries)), w)
+		}, w)
+		fs.SetFileName(fmt.Sprintf("lazyq_consumi_%s.csv", now.Format("2006-01-02")))
+		fs.SetFilter(storage.NewExtensionFileFilter([]string{".csv"}))
+		fs.Show()
+	})
+	if len(entries) == 0 {
+		exportBtn.Disable()
+	}
+
+	note := widget.NewLabel("Il costo è quello riportato dal provider o, se non lo riporta, è calcolato dai token con il listino prezzi; i modelli locali e quelli assenti dal listino contano solo i token.")
+	note.Wrapping = fyne.TextWrapWord
+	if path, err := ledgerPath(); err == nil {
+		note.SetText(note.Text + "\nRegistro: " + path)
+	}
+
+	content := container.NewBorder(
+		container.NewVBox(totalsLabel, widget.NewSeparator(), periodRadio),
+		container.NewVBox(note, exportBtn),
+		nil, nil,
+		table,
+	)
+	d := dialog.NewCustom("Consumi", "Chiudi", content, w)
+	d.Resize(fyne.NewSize(640, 500))
+	d.Show()
+}